@@ -31,4 +31,6 @@ func init() {
 	root.AddCommand(cli.NewRunCommand())
 	root.AddCommand(cli.NewGenerateCommand())
 	root.AddCommand(cli.NewTypeCommand())
+	root.AddCommand(cli.NewCtlCommand())
+	root.AddCommand(cli.NewHackCommand())
 }
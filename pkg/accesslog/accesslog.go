@@ -0,0 +1,101 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+
+	envoy_service_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Entry is the JSON representation of a received access log entry.
+type Entry struct {
+	Node    string          `json:"node"`
+	LogName string          `json:"logName"`
+	Type    string          `json:"type"`
+	Entry   json.RawMessage `json:"entry"`
+}
+
+// Server is a gRPC Access Log Service (ALS) server that writes each
+// received access log entry to an output stream as a line of JSON.
+type Server struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+var _ envoy_service_accesslog_v3.AccessLogServiceServer = &Server{}
+
+// NewServer returns a new ALS Server that writes to out.
+func NewServer(out io.Writer) *Server {
+	return &Server{out: out}
+}
+
+// Register registers the ALS service on the gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	envoy_service_accesslog_v3.RegisterAccessLogServiceServer(g, s)
+}
+
+// StreamAccessLogs implements AccessLogServiceServer.
+func (s *Server) StreamAccessLogs(stream envoy_service_accesslog_v3.AccessLogService_StreamAccessLogsServer) error {
+	var node string
+	var logName string
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Only the first message on the stream is guaranteed to
+		// have the identifier.
+		if id := msg.GetIdentifier(); id != nil {
+			node = id.GetNode().GetId()
+			logName = id.GetLogName()
+		}
+
+		for _, e := range msg.GetHttpLogs().GetLogEntry() {
+			s.write(Entry{Node: node, LogName: logName, Type: "http"}, e)
+		}
+
+		for _, e := range msg.GetTcpLogs().GetLogEntry() {
+			s.write(Entry{Node: node, LogName: logName, Type: "tcp"}, e)
+		}
+	}
+}
+
+func (s *Server) write(entry Entry, m proto.Message) {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		log.Printf("failed to marshal access log entry: %s", err)
+		return
+	}
+
+	// protojson output isn't stable, so compact it to make sure
+	// that we emit one entry per line.
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		log.Printf("failed to marshal access log entry: %s", err)
+		return
+	}
+
+	entry.Entry = compact.Bytes()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to marshal access log entry: %s", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.out.Write(append(line, '\n'))
+}
@@ -0,0 +1,140 @@
+package accesslog
+
+import (
+	"fmt"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_config_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_extensions_access_loggers_file_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	envoy_extensions_access_loggers_grpc_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
+	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_extensions_filters_network_tcp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/proto"
+)
+
+type AccessLog = envoy_config_accesslog_v3.AccessLog
+
+// NewHTTPAccessLog returns an HTTP gRPC access logger that sends
+// entries to the named gRPC cluster.
+func NewHTTPAccessLog(logName string, clusterName string) *AccessLog {
+	return newAccessLog("envoy.access_loggers.http_grpc",
+		&envoy_extensions_access_loggers_grpc_v3.HttpGrpcAccessLogConfig{
+			CommonConfig: newCommonConfig(logName, clusterName),
+		})
+}
+
+// NewTCPAccessLog returns a TCP gRPC access logger that sends
+// entries to the named gRPC cluster.
+func NewTCPAccessLog(logName string, clusterName string) *AccessLog {
+	return newAccessLog("envoy.access_loggers.tcp_grpc",
+		&envoy_extensions_access_loggers_grpc_v3.TcpGrpcAccessLogConfig{
+			CommonConfig: newCommonConfig(logName, clusterName),
+		})
+}
+
+// NewFileAccessLog returns an access logger that writes entries to
+// the given path in Envoy's default format.
+func NewFileAccessLog(path string) *AccessLog {
+	return newAccessLog("envoy.access_loggers.file",
+		&envoy_extensions_access_loggers_file_v3.FileAccessLog{
+			Path: path,
+		})
+}
+
+func newCommonConfig(logName string, clusterName string) *envoy_extensions_access_loggers_grpc_v3.CommonGrpcAccessLogConfig {
+	return &envoy_extensions_access_loggers_grpc_v3.CommonGrpcAccessLogConfig{
+		LogName:             logName,
+		GrpcService:         bootstrap.NewGrpcService(clusterName),
+		TransportApiVersion: envoy_config_core_v3.ApiVersion_V3,
+	}
+}
+
+func newAccessLog(name string, config proto.Message) *AccessLog {
+	any, err := bootstrap.MarshalAny(config)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshall %q type to Any: %s",
+			config.ProtoReflect().Descriptor().FullName(), err))
+	}
+
+	return &AccessLog{
+		Name: name,
+		ConfigType: &envoy_config_accesslog_v3.AccessLog_TypedConfig{
+			TypedConfig: any,
+		},
+	}
+}
+
+// Attach adds a gRPC access logger for the named cluster to every
+// HTTP connection manager and TCP proxy filter in the listeners of
+// the given snapshot. Entries are logged under the listener name.
+func Attach(snap *xds.Snapshot, clusterName string) error {
+	for _, r := range snap.Resources[xds.ListenerType].Items {
+		listener, ok := r.Resource.(*bootstrap.Listener)
+		if !ok {
+			continue
+		}
+
+		chains := listener.GetFilterChains()
+		if c := listener.GetDefaultFilterChain(); c != nil {
+			chains = append(chains, c)
+		}
+
+		for _, c := range chains {
+			for _, f := range c.GetFilters() {
+				if err := attachFilter(f, listener.GetName(), clusterName); err != nil {
+					return fmt.Errorf("listener %q: %w", listener.GetName(), err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func attachFilter(f *bootstrap.Filter, logName string, clusterName string) error {
+	type HTTPConnectionManager = envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager
+	type TCPProxy = envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy
+
+	typed := f.GetTypedConfig()
+	if typed == nil {
+		return nil
+	}
+
+	var config proto.Message
+
+	switch {
+	case ptypes.Is(typed, &HTTPConnectionManager{}):
+		hcm := &HTTPConnectionManager{}
+		if err := ptypes.UnmarshalAny(typed, hcm); err != nil {
+			return err
+		}
+
+		hcm.AccessLog = append(hcm.AccessLog, NewHTTPAccessLog(logName, clusterName))
+		config = hcm
+
+	case ptypes.Is(typed, &TCPProxy{}):
+		tcp := &TCPProxy{}
+		if err := ptypes.UnmarshalAny(typed, tcp); err != nil {
+			return err
+		}
+
+		tcp.AccessLog = append(tcp.AccessLog, NewTCPAccessLog(logName, clusterName))
+		config = tcp
+
+	default:
+		return nil
+	}
+
+	any, err := bootstrap.MarshalAny(config)
+	if err != nil {
+		return err
+	}
+
+	f.ConfigType = &envoy_config_listener_v3.Filter_TypedConfig{TypedConfig: any}
+	return nil
+}
@@ -0,0 +1,184 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// SocketName is the name of the Envoy admin socket in the run directory.
+const SocketName = "admin.sock"
+
+// Client makes requests to the Envoy admin API.
+type Client struct {
+	http http.Client
+}
+
+// NewClient returns a Client for the Envoy admin socket at the given path.
+func NewClient(socketPath string) *Client {
+	return &Client{
+		http: http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// get requests the given admin path and returns the response body.
+func (c *Client) get(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	u := url.URL{Scheme: "http", Host: "admin", Path: path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// Stats returns the value of each counter and gauge whose name matches
+// the regular expression filter. An empty filter matches every stat.
+func (c *Client) Stats(ctx context.Context, filter string) (map[string]uint64, error) {
+	// The histograms are in an entry without a name or value,
+	// which we skip.
+	var stats struct {
+		Stats []struct {
+			Name  string  `json:"name"`
+			Value *uint64 `json:"value"`
+		} `json:"stats"`
+	}
+
+	query := url.Values{"format": []string{"json"}}
+	if filter != "" {
+		query.Set("filter", filter)
+	}
+
+	body, err := c.get(ctx, "/stats", query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return nil, err
+	}
+
+	values := map[string]uint64{}
+
+	for _, s := range stats.Stats {
+		if s.Name != "" && s.Value != nil {
+			values[s.Name] = *s.Value
+		}
+	}
+
+	return values, nil
+}
+
+// HostHealth is the health of a cluster host, as reported by Envoy.
+type HostHealth struct {
+	Cluster string
+	Address string
+	Healthy bool
+	// Reasons are the health flags that are set for the host, e.g.
+	// "failed_outlier_check".
+	Reasons []string
+}
+
+// HostHealth returns the health of each host in each cluster.
+func (c *Client) HostHealth(ctx context.Context) ([]HostHealth, error) {
+	body, err := c.get(ctx, "/clusters", url.Values{"format": []string{"json"}})
+	if err != nil {
+		return nil, err
+	}
+
+	// Newer versions of Envoy can report fields that we don't know.
+	clusters := envoy_admin_v3.Clusters{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, &clusters); err != nil {
+		return nil, err
+	}
+
+	var hosts []HostHealth
+
+	for _, c := range clusters.GetClusterStatuses() {
+		for _, h := range c.GetHostStatuses() {
+			hosts = append(hosts, newHostHealth(c.GetName(), h))
+		}
+	}
+
+	return hosts, nil
+}
+
+func newHostHealth(cluster string, h *envoy_admin_v3.HostStatus) HostHealth {
+	host := HostHealth{
+		Cluster: cluster,
+		Healthy: true,
+	}
+
+	switch a := h.GetAddress(); {
+	case a.GetSocketAddress() != nil:
+		host.Address = net.JoinHostPort(a.GetSocketAddress().GetAddress(),
+			strconv.FormatUint(uint64(a.GetSocketAddress().GetPortValue()), 10))
+	case a.GetPipe() != nil:
+		host.Address = a.GetPipe().GetPath()
+	}
+
+	status := h.GetHealthStatus()
+
+	for _, f := range []struct {
+		name      string
+		set       bool
+		unhealthy bool
+	}{
+		{"failed_active_health_check", status.GetFailedActiveHealthCheck(), true},
+		{"failed_outlier_check", status.GetFailedOutlierCheck(), true},
+		{"failed_active_degraded_check", status.GetFailedActiveDegradedCheck(), false},
+		{"pending_dynamic_removal", status.GetPendingDynamicRemoval(), false},
+		{"pending_active_hc", status.GetPendingActiveHc(), true},
+		{"excluded_via_immediate_hc_fail", status.GetExcludedViaImmediateHcFail(), true},
+		{"active_hc_timeout", status.GetActiveHcTimeout(), true},
+	} {
+		if f.set {
+			host.Reasons = append(host.Reasons, f.name)
+			host.Healthy = host.Healthy && !f.unhealthy
+		}
+	}
+
+	switch eds := status.GetEdsHealthStatus(); eds {
+	case envoy_config_core_v3.HealthStatus_UNKNOWN, envoy_config_core_v3.HealthStatus_HEALTHY,
+		envoy_config_core_v3.HealthStatus_DEGRADED:
+	default:
+		host.Reasons = append(host.Reasons, "eds_health_status="+eds.String())
+		host.Healthy = false
+	}
+
+	return host
+}
@@ -0,0 +1,111 @@
+package authz
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Server is an in-process implementation of the Envoy external
+// authorization service that decides requests with a set of rules.
+type Server struct {
+	rules *Rules
+}
+
+var _ envoy_service_auth_v3.AuthorizationServer = &Server{}
+
+// NewServer returns an authorization Server for the given rules. If
+// there are no rules, all requests are allowed.
+func NewServer(rules *Rules) *Server {
+	if rules == nil {
+		rules = &Rules{Default: "allow"}
+	}
+
+	return &Server{rules: rules}
+}
+
+// Register registers the authorization service on the gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	envoy_service_auth_v3.RegisterAuthorizationServer(g, s)
+}
+
+func newHeaders(headers map[string]string) []*envoy_config_core_v3.HeaderValueOption {
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	var result []*envoy_config_core_v3.HeaderValueOption
+	for _, k := range names {
+		result = append(result, &envoy_config_core_v3.HeaderValueOption{
+			Header: &envoy_config_core_v3.HeaderValue{Key: k, Value: headers[k]},
+			Append: wrapperspb.Bool(false),
+		})
+	}
+
+	return result
+}
+
+// Check implements AuthorizationServer.
+func (s *Server) Check(ctx context.Context, check *envoy_service_auth_v3.CheckRequest) (*envoy_service_auth_v3.CheckResponse, error) {
+	attrs := check.GetAttributes()
+	httpReq := attrs.GetRequest().GetHttp()
+
+	req := &Request{
+		Method:   httpReq.GetMethod(),
+		Path:     httpReq.GetPath(),
+		Headers:  httpReq.GetHeaders(),
+		SourceIP: net.ParseIP(attrs.GetSource().GetAddress().GetSocketAddress().GetAddress()),
+	}
+
+	action := s.rules.Default
+	ruleName := "default"
+
+	rule := s.rules.Decide(req)
+	if rule != nil {
+		action = rule.Action
+		ruleName = rule.Name
+	}
+
+	log.Printf("authz: %s %s %s from %s (rule %q)",
+		action, req.Method, req.Path, req.SourceIP, ruleName)
+
+	if action == "allow" {
+		resp := &envoy_service_auth_v3.OkHttpResponse{}
+		if rule != nil {
+			resp.Headers = newHeaders(rule.Headers)
+		}
+
+		return &envoy_service_auth_v3.CheckResponse{
+			Status:       &status.Status{Code: int32(codes.OK)},
+			HttpResponse: &envoy_service_auth_v3.CheckResponse_OkResponse{OkResponse: resp},
+		}, nil
+	}
+
+	resp := &envoy_service_auth_v3.DeniedHttpResponse{
+		Status: &envoy_type_v3.HttpStatus{Code: envoy_type_v3.StatusCode_Forbidden},
+	}
+
+	if rule != nil {
+		resp.Headers = newHeaders(rule.Headers)
+		if rule.Status != 0 {
+			resp.Status.Code = envoy_type_v3.StatusCode(rule.Status)
+		}
+	}
+
+	return &envoy_service_auth_v3.CheckResponse{
+		Status:       &status.Status{Code: int32(codes.PermissionDenied)},
+		HttpResponse: &envoy_service_auth_v3.CheckResponse_DeniedResponse{DeniedResponse: resp},
+	}, nil
+}
@@ -0,0 +1,166 @@
+package authz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Match selects the requests that a rule applies to. All the
+// specified matchers must match.
+type Match struct {
+	// Path matches the request path prefix.
+	Path string `json:"path,omitempty"`
+
+	// Headers matches request header values. An empty value
+	// matches any request that has the header.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// SourceIP matches the source address against a CIDR range.
+	SourceIP string `json:"sourceIP,omitempty"`
+
+	sourceNet *net.IPNet
+}
+
+// Rule is an authorization decision for matching requests.
+type Rule struct {
+	Name  string `json:"name"`
+	Match Match  `json:"match"`
+
+	// Action is either "allow" or "deny".
+	Action string `json:"action"`
+
+	// Status is the HTTP status for denied requests.
+	Status uint32 `json:"status,omitempty"`
+
+	// Headers are injected into allowed requests before they are
+	// sent upstream, or into the response for denied requests.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Rules is an ordered set of authorization rules. The first rule that
+// matches a request decides it, and requests that don't match any
+// rule get the default action. For example:
+//
+//	default: deny
+//	rules:
+//	- name: health
+//	  match:
+//	    path: /healthz
+//	  action: allow
+//	- name: admins
+//	  match:
+//	    headers:
+//	      x-user: admin
+//	    sourceIP: 127.0.0.0/8
+//	  action: allow
+//	  headers:
+//	    x-authz-user: admin
+type Rules struct {
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+func validAction(action string) bool {
+	switch action {
+	case "allow", "deny":
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadRules reads a YAML authorization rules file.
+func ReadRules(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r Rules
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if r.Default == "" {
+		r.Default = "allow"
+	}
+
+	if !validAction(r.Default) {
+		return nil, fmt.Errorf("%s: invalid default action %q", path, r.Default)
+	}
+
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		if !validAction(rule.Action) {
+			return nil, fmt.Errorf("%s: rule %q: invalid action %q", path, rule.Name, rule.Action)
+		}
+
+		if rule.Match.SourceIP != "" {
+			_, n, err := net.ParseCIDR(rule.Match.SourceIP)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %q: %w", path, rule.Name, err)
+			}
+
+			rule.Match.sourceNet = n
+		}
+
+		// Envoy sends lower-cased header names.
+		headers := map[string]string{}
+		for k, v := range rule.Match.Headers {
+			headers[strings.ToLower(k)] = v
+		}
+
+		rule.Match.Headers = headers
+	}
+
+	return &r, nil
+}
+
+// Request is the subset of request attributes that rules match.
+type Request struct {
+	Method   string
+	Path     string
+	Headers  map[string]string
+	SourceIP net.IP
+}
+
+// Matches returns true if the request matches.
+func (m *Match) Matches(req *Request) bool {
+	if m.Path != "" && !strings.HasPrefix(req.Path, m.Path) {
+		return false
+	}
+
+	for k, v := range m.Headers {
+		val, ok := req.Headers[k]
+		if !ok || (v != "" && v != val) {
+			return false
+		}
+	}
+
+	if m.sourceNet != nil && (req.SourceIP == nil || !m.sourceNet.Contains(req.SourceIP)) {
+		return false
+	}
+
+	return true
+}
+
+// Decide returns the rule that matches the request, or nil if the
+// request gets the default action.
+func (r *Rules) Decide(req *Request) *Rule {
+	for i := range r.Rules {
+		if r.Rules[i].Match.Matches(req) {
+			return &r.Rules[i]
+		}
+	}
+
+	return nil
+}
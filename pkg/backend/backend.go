@@ -0,0 +1,274 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	protov1 "github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Kinds is the set of supported backend kinds.
+var Kinds = []string{"http", "tcp", "grpc", "udp"}
+
+// Backend is an in-process upstream server that hacks can proxy to.
+type Backend struct {
+	// Kind is the kind of backend server.
+	Kind string
+
+	// Name is the name of the Envoy cluster for the backend.
+	Name string
+
+	listener  net.Listener
+	conn      net.PacketConn
+	health    *health.Server
+	unhealthy int32
+}
+
+// Status is the health status of a backend.
+type Status struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind,omitempty"`
+	Address string `json:"address,omitempty"`
+	Healthy bool   `json:"healthy"`
+}
+
+// Listen returns a Backend of the given kind that listens on the
+// given network ("unix" or "tcp", or "udp" for the udp kind) and
+// address.
+func Listen(kind string, name string, network string, address string) (*Backend, error) {
+	switch kind {
+	case "http", "tcp", "grpc":
+	case "udp":
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %w", name, err)
+		}
+
+		return &Backend{
+			Kind: kind,
+			Name: name,
+			conn: conn,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid backend kind %q", kind)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("backend %q: %w", name, err)
+	}
+
+	b := &Backend{
+		Kind:     kind,
+		Name:     name,
+		listener: l,
+	}
+
+	if kind == "grpc" {
+		b.health = health.NewServer()
+	}
+
+	return b, nil
+}
+
+// Healthy returns whether the backend is healthy.
+func (b *Backend) Healthy() bool {
+	return atomic.LoadInt32(&b.unhealthy) == 0
+}
+
+// SetHealthy makes the backend healthy or unhealthy. An unhealthy
+// HTTP backend responds with a 503 status, an unhealthy TCP backend
+// closes connections as soon as they are accepted, an unhealthy gRPC
+// backend reports NOT_SERVING to health checks and an unhealthy UDP
+// backend drops datagrams.
+func (b *Backend) SetHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&b.unhealthy, 0)
+	} else {
+		atomic.StoreInt32(&b.unhealthy, 1)
+	}
+
+	if b.health != nil {
+		status := grpc_health_v1.HealthCheckResponse_SERVING
+		if !healthy {
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+
+		b.health.SetServingStatus("", status)
+	}
+}
+
+// Status returns the health status of the backend.
+func (b *Backend) Status() Status {
+	return Status{
+		Name:    b.Name,
+		Kind:    b.Kind,
+		Address: b.Addr().String(),
+		Healthy: b.Healthy(),
+	}
+}
+
+// Addr returns the address the backend is listening on.
+func (b *Backend) Addr() net.Addr {
+	if b.conn != nil {
+		return b.conn.LocalAddr()
+	}
+
+	return b.listener.Addr()
+}
+
+// Serve serves requests until the backend listener is closed.
+func (b *Backend) Serve() error {
+	log.Printf("serving %s backend %q on %s", b.Kind, b.Name, b.Addr())
+
+	switch b.Kind {
+	case "http":
+		srv := http.Server{Handler: http.HandlerFunc(b.echoHTTP)}
+		return srv.Serve(b.listener)
+	case "tcp":
+		return b.echoTCP()
+	case "grpc":
+		srv := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(srv, b.health)
+		return srv.Serve(b.listener)
+	case "udp":
+		return b.echoUDP()
+	default:
+		return fmt.Errorf("invalid backend kind %q", b.Kind)
+	}
+}
+
+// Close stops the backend listener.
+func (b *Backend) Close() error {
+	if b.conn != nil {
+		return b.conn.Close()
+	}
+
+	return b.listener.Close()
+}
+
+// EchoResponse is the body of the HTTP echo backend response.
+type EchoResponse struct {
+	Backend    string              `json:"backend"`
+	Method     string              `json:"method"`
+	Host       string              `json:"host"`
+	Path       string              `json:"path"`
+	Proto      string              `json:"proto"`
+	RemoteAddr string              `json:"remoteAddr"`
+	Headers    map[string][]string `json:"headers"`
+}
+
+// echoHTTP responds with a JSON description of the request.
+func (b *Backend) echoHTTP(w http.ResponseWriter, r *http.Request) {
+	if !b.Healthy() {
+		http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(&EchoResponse{
+		Backend:    b.Name,
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.RequestURI(),
+		Proto:      r.Proto,
+		RemoteAddr: r.RemoteAddr,
+		Headers:    r.Header,
+	})
+}
+
+// echoTCP copies each connection's input back to it.
+func (b *Backend) echoTCP() error {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		if !b.Healthy() {
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+// echoUDP sends each datagram back to where it came from.
+func (b *Backend) echoUDP() error {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, addr, err := b.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		if !b.Healthy() {
+			continue
+		}
+
+		if _, err := b.conn.WriteTo(buf[:n], addr); err != nil {
+			log.Printf("backend %q: %s", b.Name, err)
+		}
+	}
+}
+
+// Cluster returns a STATIC cluster for the backend address.
+func (b *Backend) Cluster() *bootstrap.Cluster {
+	var addr *bootstrap.Address
+
+	switch a := b.Addr().(type) {
+	case *net.UnixAddr:
+		addr = bootstrap.NewPipeAddress(&bootstrap.PipeAddress{Path: a.Name})
+	case *net.TCPAddr:
+		addr = bootstrap.NewTCPAddress(a)
+	case *net.UDPAddr:
+		addr = bootstrap.NewUDPHostAddress(a)
+	}
+
+	c := bootstrap.NewStaticCluster(b.Name, addr)
+
+	if b.Kind == "grpc" {
+		c.Http2ProtocolOptions = &envoy_config_core_v3.Http2ProtocolOptions{}
+	}
+
+	return c
+}
+
+// Snapshot returns a snapshot containing the cluster for each backend.
+func Snapshot(backends []*Backend) xds.Snapshot {
+	sorted := append([]*Backend(nil), backends...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var clusters []protov1.Message
+	for _, b := range sorted {
+		clusters = append(clusters, b.Cluster())
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ClusterType] = xds.NewResources("", clusters...)
+
+	return snap
+}
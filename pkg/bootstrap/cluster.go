@@ -0,0 +1,89 @@
+package bootstrap
+
+import (
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/golang/protobuf/ptypes"
+)
+
+type Cluster = envoy_config_cluster_v3.Cluster
+
+type ClusterLoadAssignment = envoy_config_endpoint_v3.ClusterLoadAssignment
+type LocalityLbEndpoints = envoy_config_endpoint_v3.LocalityLbEndpoints
+type LbEndpoint = envoy_config_endpoint_v3.LbEndpoint
+type Endpoint = envoy_config_endpoint_v3.Endpoint
+
+type Locality = envoy_config_core_v3.Locality
+type HealthStatus = envoy_config_core_v3.HealthStatus
+
+// NewLbEndpoint returns a *LbEndpoint for the given address.
+func NewLbEndpoint(addr *Address) *LbEndpoint {
+	return &LbEndpoint{
+		HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{
+			Endpoint: &Endpoint{
+				Address: addr,
+			},
+		},
+	}
+}
+
+// NewStaticCluster returns a STATIC cluster whose load assignment
+// contains the given addresses.
+func NewStaticCluster(name string, addrs ...*Address) *Cluster {
+	var endpoints []*LbEndpoint
+
+	for _, a := range addrs {
+		endpoints = append(endpoints, NewLbEndpoint(a))
+	}
+
+	return &Cluster{
+		Name:           name,
+		ConnectTimeout: ptypes.DurationProto(time.Second * 10),
+		ClusterDiscoveryType: &envoy_config_cluster_v3.Cluster_Type{
+			Type: envoy_config_cluster_v3.Cluster_STATIC,
+		},
+		LoadAssignment: &ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints: []*LocalityLbEndpoints{
+				&LocalityLbEndpoints{
+					LbEndpoints: endpoints,
+				},
+			},
+		},
+	}
+}
+
+// NewEdsCluster returns an EDS cluster whose endpoints are obtained
+// over ADS.
+func NewEdsCluster(name string) *Cluster {
+	return &Cluster{
+		Name:           name,
+		ConnectTimeout: ptypes.DurationProto(time.Second * 10),
+		ClusterDiscoveryType: &envoy_config_cluster_v3.Cluster_Type{
+			Type: envoy_config_cluster_v3.Cluster_EDS,
+		},
+		EdsClusterConfig: &envoy_config_cluster_v3.Cluster_EdsClusterConfig{
+			EdsConfig: &ConfigSource{
+				ConfigSourceSpecifier: NewAdsConfigSource(),
+				ResourceApiVersion:    envoy_config_core_v3.ApiVersion_V3,
+			},
+		},
+	}
+}
+
+// NewOriginalDstCluster returns an ORIGINAL_DST cluster, which
+// connects to the original destination address of each downstream
+// connection.
+func NewOriginalDstCluster(name string) *Cluster {
+	return &Cluster{
+		Name:           name,
+		ConnectTimeout: ptypes.DurationProto(time.Second * 10),
+		ClusterDiscoveryType: &envoy_config_cluster_v3.Cluster_Type{
+			Type: envoy_config_cluster_v3.Cluster_ORIGINAL_DST,
+		},
+		LbPolicy: envoy_config_cluster_v3.Cluster_CLUSTER_PROVIDED,
+	}
+}
@@ -43,6 +43,23 @@ func NewFilter(name string, config proto.Message) *Filter {
 	}
 }
 
+func NewListenerFilter(name string, config proto.Message) *ListenerFilter {
+	type TypedConfig = envoy_config_listener_v3.ListenerFilter_TypedConfig
+
+	any, err := MarshalAny(config)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshall %q type to Any: %s",
+			config.ProtoReflect().Descriptor().FullName(), err))
+	}
+
+	return &ListenerFilter{
+		Name: name,
+		ConfigType: &TypedConfig{
+			TypedConfig: any,
+		},
+	}
+}
+
 func NewHTTPFilter(name string, config proto.Message) *HTTPFilter {
 	type TypedConfig = envoy_extensions_filters_network_http_connection_manager_v3.HttpFilter_TypedConfig
 
@@ -0,0 +1,90 @@
+package bootstrap
+
+import (
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type SocketOption = envoy_config_core_v3.SocketOption
+
+// PlatformMetadataKey is the node metadata field that holds the
+// platform that Envoy runs on.
+const PlatformMetadataKey = "envoy-bootstrap.platform"
+
+// Platform is the operating system and architecture that an Envoy
+// node runs on, using the Go names, e.g. "linux" and "amd64". Some
+// listener options depend on the platform, since Envoy rejects or
+// crashes on options that the platform doesn't support.
+type Platform struct {
+	OS   string
+	Arch string
+}
+
+// SetPlatform records the platform in the node metadata, so that the
+// management server can see it.
+func SetPlatform(node *Node, p Platform) {
+	if node.Metadata == nil {
+		node.Metadata = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	}
+
+	node.Metadata.Fields[PlatformMetadataKey] = structpb.NewStructValue(&structpb.Struct{
+		Fields: map[string]*structpb.Value{
+			"os":   structpb.NewStringValue(p.OS),
+			"arch": structpb.NewStringValue(p.Arch),
+		},
+	})
+}
+
+// NodePlatform returns the platform recorded in the node metadata by
+// SetPlatform. The platform is empty if the node doesn't have one.
+func NodePlatform(node *Node) Platform {
+	fields := node.GetMetadata().GetFields()[PlatformMetadataKey].GetStructValue().GetFields()
+
+	return Platform{
+		OS:   fields["os"].GetStringValue(),
+		Arch: fields["arch"].GetStringValue(),
+	}
+}
+
+// Freebind returns the listener freebind option, which is only set
+// on Linux.
+//
+// https://github.com/envoyproxy/envoy/issues/11340
+func (p Platform) Freebind() *wrappers.BoolValue {
+	if p.OS == "linux" {
+		return True()
+	}
+
+	return nil
+}
+
+// ReusePort returns whether listeners should use SO_REUSEPORT. Only
+// Linux balances connections across the sockets bound to a port.
+func (p Platform) ReusePort() bool {
+	return p.OS == "linux"
+}
+
+// KeepaliveSocketOption returns a socket option that enables TCP
+// keepalive, or nil if the platform is unknown. The option level and
+// name are the platform's values of SOL_SOCKET and SO_KEEPALIVE.
+func (p Platform) KeepaliveSocketOption() *SocketOption {
+	var level, name int64
+
+	switch p.OS {
+	case "linux":
+		level, name = 1, 9
+	case "darwin", "freebsd", "netbsd", "openbsd":
+		level, name = 0xffff, 0x8
+	default:
+		return nil
+	}
+
+	return &SocketOption{
+		Description: "SO_KEEPALIVE",
+		Level:       level,
+		Name:        name,
+		Value:       &envoy_config_core_v3.SocketOption_IntValue{IntValue: 1},
+		State:       envoy_config_core_v3.SocketOption_STATE_PREBIND,
+	}
+}
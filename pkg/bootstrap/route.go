@@ -0,0 +1,91 @@
+package bootstrap
+
+import (
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+)
+
+type RouteConfiguration = envoy_config_route_v3.RouteConfiguration
+type VirtualHost = envoy_config_route_v3.VirtualHost
+type Route = envoy_config_route_v3.Route
+type RouteMatch = envoy_config_route_v3.RouteMatch
+type RouteAction = envoy_config_route_v3.RouteAction
+type RateLimit = envoy_config_route_v3.RateLimit
+type DirectResponseAction = envoy_config_route_v3.DirectResponseAction
+type RedirectAction = envoy_config_route_v3.RedirectAction
+type WeightedCluster = envoy_config_route_v3.WeightedCluster
+type ClusterWeight = envoy_config_route_v3.WeightedCluster_ClusterWeight
+
+// NewPrefixMatch returns a *RouteMatch for the given path prefix.
+func NewPrefixMatch(prefix string) *RouteMatch {
+	return &RouteMatch{
+		PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{
+			Prefix: prefix,
+		},
+	}
+}
+
+// NewPathMatch returns a *RouteMatch for the given exact path.
+func NewPathMatch(path string) *RouteMatch {
+	return &RouteMatch{
+		PathSpecifier: &envoy_config_route_v3.RouteMatch_Path{
+			Path: path,
+		},
+	}
+}
+
+// NewClusterRoute returns a *Route that forwards requests matching
+// the path prefix to the named cluster.
+func NewClusterRoute(prefix string, clusterName string) *Route {
+	return &Route{
+		Match: NewPrefixMatch(prefix),
+		Action: &envoy_config_route_v3.Route_Route{
+			Route: &RouteAction{
+				ClusterSpecifier: &envoy_config_route_v3.RouteAction_Cluster{
+					Cluster: clusterName,
+				},
+			},
+		},
+	}
+}
+
+// NewDirectResponseRoute returns a *Route that responds to requests
+// matching the path prefix with the given status and body.
+func NewDirectResponseRoute(prefix string, status uint32, body string) *Route {
+	r := &envoy_config_route_v3.DirectResponseAction{
+		Status: status,
+	}
+
+	if body != "" {
+		r.Body = NewInlineString(body)
+	}
+
+	return &Route{
+		Match: NewPrefixMatch(prefix),
+		Action: &envoy_config_route_v3.Route_DirectResponse{
+			DirectResponse: r,
+		},
+	}
+}
+
+// NewWeightedClusterRoute returns a *Route that splits requests
+// matching the path prefix across the clusters by weight.
+func NewWeightedClusterRoute(prefix string, clusters ...*ClusterWeight) *Route {
+	total := uint32(0)
+	for _, c := range clusters {
+		total += c.GetWeight().GetValue()
+	}
+
+	return &Route{
+		Match: NewPrefixMatch(prefix),
+		Action: &envoy_config_route_v3.Route_Route{
+			Route: &RouteAction{
+				ClusterSpecifier: &envoy_config_route_v3.RouteAction_WeightedClusters{
+					WeightedClusters: &WeightedCluster{
+						Clusters:    clusters,
+						TotalWeight: UInt32(total),
+					},
+				},
+			},
+		},
+	}
+}
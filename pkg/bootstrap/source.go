@@ -8,19 +8,27 @@ type ApiConfigSource = envoy_config_core_v3.ConfigSource_ApiConfigSource
 type PathConfigSource = envoy_config_core_v3.ConfigSource_Path
 type AdsConfigSource = envoy_config_core_v3.ConfigSource_Ads
 
+type GrpcService = envoy_config_core_v3.GrpcService
+
+// NewGrpcService returns a *GrpcService that uses the Envoy gRPC
+// client to connect to the named cluster.
+func NewGrpcService(clusterName string) *GrpcService {
+	return &GrpcService{
+		TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
+			EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{
+				ClusterName: clusterName,
+			},
+		},
+	}
+}
+
 // NewApiConfigSource returns a *ApiConfigSource for the named GRPC cluster.
 func NewApiConfigSource(clusterName string) *ApiConfigSource {
 	api := &ApiConfigSource{
 		ApiConfigSource: &envoy_config_core_v3.ApiConfigSource{
 			ApiType: envoy_config_core_v3.ApiConfigSource_GRPC,
 			GrpcServices: []*envoy_config_core_v3.GrpcService{
-				&envoy_config_core_v3.GrpcService{
-					TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
-						EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{
-							ClusterName: clusterName,
-						},
-					},
-				},
+				NewGrpcService(clusterName),
 			},
 			RefreshDelay:              nil,
 			RequestTimeout:            nil,
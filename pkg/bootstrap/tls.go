@@ -0,0 +1,66 @@
+package bootstrap
+
+import (
+	"fmt"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+)
+
+type DownstreamTlsContext = envoy_extensions_transport_sockets_tls_v3.DownstreamTlsContext
+type CommonTlsContext = envoy_extensions_transport_sockets_tls_v3.CommonTlsContext
+type TlsCertificate = envoy_extensions_transport_sockets_tls_v3.TlsCertificate
+type CertificateValidationContext = envoy_extensions_transport_sockets_tls_v3.CertificateValidationContext
+
+// NewTLSCertificate returns a *TlsCertificate with the given inline
+// PEM certificate chain and private key.
+func NewTLSCertificate(certPEM []byte, keyPEM []byte) *TlsCertificate {
+	return &TlsCertificate{
+		CertificateChain: NewInlineBytes(certPEM),
+		PrivateKey:       NewInlineBytes(keyPEM),
+	}
+}
+
+// NewDownstreamTLSContext returns a *DownstreamTlsContext that
+// presents the given certificate. If the client CA is not empty,
+// client certificates are validated against it. Clients only have to
+// present a certificate if requireClientCert is true.
+func NewDownstreamTLSContext(cert *TlsCertificate, clientCAPEM []byte, requireClientCert bool) *DownstreamTlsContext {
+	ctx := &DownstreamTlsContext{
+		CommonTlsContext: &CommonTlsContext{
+			TlsCertificates: []*TlsCertificate{cert},
+			AlpnProtocols:   []string{"h2", "http/1.1"},
+		},
+	}
+
+	if requireClientCert {
+		ctx.RequireClientCertificate = True()
+	}
+
+	if len(clientCAPEM) > 0 {
+		ctx.CommonTlsContext.ValidationContextType = &envoy_extensions_transport_sockets_tls_v3.CommonTlsContext_ValidationContext{
+			ValidationContext: &CertificateValidationContext{
+				TrustedCa: NewInlineBytes(clientCAPEM),
+			},
+		}
+	}
+
+	return ctx
+}
+
+// NewDownstreamTLSTransportSocket returns a TLS *TransportSocket for
+// the given context.
+func NewDownstreamTLSTransportSocket(ctx *DownstreamTlsContext) *TransportSocket {
+	any, err := MarshalAny(ProtoV2(ctx))
+	if err != nil {
+		panic(fmt.Errorf("failed to marshall %q type to Any: %s",
+			ProtoV2(ctx).ProtoReflect().Descriptor().FullName(), err))
+	}
+
+	return &TransportSocket{
+		Name: "envoy.transport_sockets.tls",
+		ConfigType: &envoy_config_core_v3.TransportSocket_TypedConfig{
+			TypedConfig: any,
+		},
+	}
+}
@@ -3,12 +3,16 @@ package bootstrap
 import (
 	"fmt"
 	"io"
+	"math"
+	"net"
 
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/jpeach/envoy-bootstrap/pkg/must"
 
 	envoy_config_bootstrap_v3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
 	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_metrics_v3 "github.com/envoyproxy/go-control-plane/envoy/config/metrics/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	protov1 "github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/wrappers"
@@ -20,6 +24,9 @@ import (
 
 type Bootstrap = envoy_config_bootstrap_v3.Bootstrap
 type Admin = envoy_config_bootstrap_v3.Admin
+type ClusterManager = envoy_config_bootstrap_v3.ClusterManager
+
+type StatsSink = envoy_config_metrics_v3.StatsSink
 
 type Node = envoy_config_core_v3.Node
 
@@ -32,6 +39,10 @@ type NamedPort = envoy_config_core_v3.SocketAddress_NamedPort
 
 type TransportSocket = envoy_config_core_v3.TransportSocket
 
+type DataSource = envoy_config_core_v3.DataSource
+
+type FractionalPercent = envoy_type_v3.FractionalPercent
+
 func NewSocketAddress(addr *SocketAddress) *Address {
 	return &Address{Address: &envoy_config_core_v3.Address_SocketAddress{SocketAddress: addr}}
 }
@@ -40,6 +51,22 @@ func NewPipeAddress(addr *PipeAddress) *Address {
 	return &Address{Address: &envoy_config_core_v3.Address_Pipe{Pipe: addr}}
 }
 
+// NewTCPAddress returns an *Address for the given TCP address.
+func NewTCPAddress(addr *net.TCPAddr) *Address {
+	return NewSocketAddress(&SocketAddress{
+		Protocol:      envoy_config_core_v3.SocketAddress_TCP,
+		Address:       addr.IP.String(),
+		PortSpecifier: NewPortValue(uint32(addr.Port)),
+	})
+}
+
+// NewUDPHostAddress returns an *Address for a cluster host at the
+// given UDP address. The UDP proxy only uses the IP and port of
+// cluster hosts, so this is a TCP socket address.
+func NewUDPHostAddress(addr *net.UDPAddr) *Address {
+	return NewTCPAddress(&net.TCPAddr{IP: addr.IP, Port: addr.Port})
+}
+
 func NewPortValue(val uint32) *PortValue {
 	return &PortValue{PortValue: val}
 }
@@ -48,6 +75,20 @@ func NewNamedPort(name string) *NamedPort {
 	return &NamedPort{NamedPort: name}
 }
 
+// NewInlineString returns a *DataSource containing the given string.
+func NewInlineString(s string) *DataSource {
+	return &DataSource{
+		Specifier: &envoy_config_core_v3.DataSource_InlineString{InlineString: s},
+	}
+}
+
+// NewInlineBytes returns a *DataSource containing the given bytes.
+func NewInlineBytes(b []byte) *DataSource {
+	return &DataSource{
+		Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: b},
+	}
+}
+
 func NewMessage(typeName string) (proto.Message, error) {
 	mtype, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(typeName))
 	if err != nil {
@@ -85,6 +126,16 @@ func Int32(i int32) *wrappers.Int32Value {
 	return &wrappers.Int32Value{Value: i}
 }
 
+// NewPercent returns a *FractionalPercent for the given percentage.
+// The denominator is a million, so that fractional percentages
+// down to 0.0001% can be expressed.
+func NewPercent(percent float64) *FractionalPercent {
+	return &FractionalPercent{
+		Numerator:   uint32(math.Round(percent * 10000)),
+		Denominator: envoy_type_v3.FractionalPercent_MILLION,
+	}
+}
+
 func MarshalAny(message proto.Message) (*any.Any, error) {
 	return ptypes.MarshalAny(ProtoV1(message))
 }
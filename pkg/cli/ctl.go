@@ -0,0 +1,318 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/backend"
+	"github.com/jpeach/envoy-bootstrap/pkg/control"
+	"github.com/jpeach/envoy-bootstrap/pkg/hacks"
+	"github.com/jpeach/envoy-bootstrap/pkg/loadstats"
+	"github.com/jpeach/envoy-bootstrap/pkg/metrics"
+	"github.com/jpeach/envoy-bootstrap/pkg/must"
+	"github.com/jpeach/envoy-bootstrap/pkg/ratelimit"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	"github.com/spf13/cobra"
+)
+
+// NewCtlCommand returns a "ctl" subcommand.
+func NewCtlCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ctl",
+		Short: "Inspect and control a running envoy-bootstrap",
+	}
+
+	cmd.PersistentFlags().String("socket", "", "Control socket path (defaults to the most recent run)")
+
+	cmd.AddCommand(
+		Defaults(NewCtlLoadCommand()),
+		Defaults(NewCtlStatsCommand()),
+		Defaults(NewCtlRateLimitCommand()),
+		Defaults(NewCtlAcksCommand()),
+		Defaults(NewCtlFaultCommand()),
+		Defaults(NewCtlBackendsCommand()),
+	)
+
+	return cmd
+}
+
+// newControlClient returns a control client for the socket given by
+// the "--socket" flag, or for the most recently started run.
+func newControlClient(cmd *cobra.Command) (*control.Client, error) {
+	socketPath := must.String(cmd.Flags().GetString("socket"))
+	if socketPath == "" {
+		var err error
+		if socketPath, err = control.FindSocket(); err != nil {
+			return nil, err
+		}
+	}
+
+	return control.NewClient(socketPath), nil
+}
+
+// NewCtlLoadCommand ...
+func NewCtlLoadCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "load",
+		Short: "Show the load reported by Envoy for each cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newControlClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			var stats []loadstats.ClusterStats
+			if err := client.Get("/load", &stats); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 8, 8, 2, ' ', 0)
+			fmt.Fprintf(w, "CLUSTER\tLOCALITY\tPRIORITY\tSUCCESS\tERROR\tISSUED\tIN PROGRESS\tDROPPED\n")
+
+			for _, c := range stats {
+				name := c.Cluster
+				if c.ServiceName != "" {
+					name = fmt.Sprintf("%s (%s)", c.Cluster, c.ServiceName)
+				}
+
+				fmt.Fprintf(w, "%s\t\t\t\t\t\t\t%d\n", name, c.DroppedRequests)
+
+				for _, l := range c.Localities {
+					locality := strings.Trim(strings.Join([]string{l.Region, l.Zone, l.SubZone}, "/"), "/")
+					if locality == "" {
+						locality = "-"
+					}
+
+					fmt.Fprintf(w, "\t%s\t%d\t%d\t%d\t%d\t%d\t\n",
+						locality, l.Priority, l.SuccessfulRequests, l.ErrorRequests,
+						l.IssuedRequests, l.RequestsInProgress)
+				}
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+// NewCtlStatsCommand ...
+func NewCtlStatsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats [--filter REGEX] [--watch]",
+		Short: "Show the latest Envoy counters and gauges",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newControlClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			query := url.Values{}
+			if filter := must.String(cmd.Flags().GetString("filter")); filter != "" {
+				query.Set("filter", filter)
+			}
+
+			show := func() error {
+				var stats []metrics.Stat
+				if err := client.Get("/stats?"+query.Encode(), &stats); err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 8, 8, 2, ' ', 0)
+				for _, s := range stats {
+					fmt.Fprintf(w, "%s\t%s\t%v\n", s.Name, s.Type, s.Value)
+				}
+
+				return w.Flush()
+			}
+
+			if !must.Bool(cmd.Flags().GetBool("watch")) {
+				return show()
+			}
+
+			interval := must.Duration(cmd.Flags().GetDuration("interval"))
+
+			for {
+				fmt.Fprintf(cmd.OutOrStdout(), "--- %s\n", time.Now().Format(time.RFC3339))
+				if err := show(); err != nil {
+					return err
+				}
+
+				time.Sleep(interval)
+			}
+		},
+	}
+
+	cmd.Flags().String("filter", "", "Regular expression to filter stat names")
+	cmd.Flags().Bool("watch", false, "Repeatedly show the stats")
+	cmd.Flags().Duration("interval", metrics.DefaultFlushInterval, "Interval between updates when watching")
+
+	return cmd
+}
+
+// NewCtlRateLimitCommand ...
+func NewCtlRateLimitCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ratelimit",
+		Short: "Show the request counts of the rate limit service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newControlClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			var counts []ratelimit.Counts
+			if err := client.Get("/ratelimit", &counts); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 8, 8, 2, ' ', 0)
+			fmt.Fprintf(w, "DOMAIN\tDESCRIPTOR\tLIMIT\tALLOWED\tOVER LIMIT\n")
+
+			for _, c := range counts {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n",
+					c.Domain, c.Descriptor, c.Limit, c.Allowed, c.OverLimit)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+// NewCtlAcksCommand ...
+func NewCtlAcksCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "acks",
+		Short: "Show how long Envoy takes to ACK or NACK each xDS resource type",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newControlClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			var stats []xds.AckStats
+			if err := client.Get("/acks", &stats); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 8, 8, 2, ' ', 0)
+			fmt.Fprintf(w, "TYPE\tSENT\tACK\tNACK\tPENDING\tVERSION\tLAST\tMEAN\tMAX\n")
+
+			for _, s := range stats {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+					s.TypeURL, s.Sent, s.Acks, s.Nacks, s.Pending, s.LastVersion,
+					s.LastLatency.Round(time.Microsecond),
+					s.MeanLatency.Round(time.Microsecond),
+					s.MaxLatency.Round(time.Microsecond))
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+// NewCtlFaultCommand ...
+func NewCtlFaultCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fault PORT [--delay-percent N] [--abort-percent N]",
+		Short: "Show or change the fault percentages of a fault hack",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, err := strconv.ParseInt(args[0], 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid port %q", args[0])
+			}
+
+			client, err := newControlClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			var percentages hacks.FaultPercentages
+			if err := client.Get(hacks.FaultControlPath(port), &percentages); err != nil {
+				return err
+			}
+
+			if cmd.Flags().Changed("delay-percent") || cmd.Flags().Changed("abort-percent") {
+				if cmd.Flags().Changed("delay-percent") {
+					percentages.DelayPercent = must.Float64(cmd.Flags().GetFloat64("delay-percent"))
+				}
+
+				if cmd.Flags().Changed("abort-percent") {
+					percentages.AbortPercent = must.Float64(cmd.Flags().GetFloat64("abort-percent"))
+				}
+
+				if err := client.Post(hacks.FaultControlPath(port), percentages, &percentages); err != nil {
+					return err
+				}
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "delay %v%%, abort %v%%\n",
+				percentages.DelayPercent, percentages.AbortPercent)
+			return nil
+		},
+	}
+
+	cmd.Flags().Float64("delay-percent", 0, "Percentage of requests to delay")
+	cmd.Flags().Float64("abort-percent", 0, "Percentage of requests to abort")
+
+	return cmd
+}
+
+// NewCtlBackendsCommand ...
+func NewCtlBackendsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "backends [NAME healthy|unhealthy]",
+		Short: "Show the built-in backends, or make one healthy or unhealthy",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 && len(args) != 2 {
+				return fmt.Errorf("expected no arguments, or a backend name and health")
+			}
+
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newControlClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			var status []backend.Status
+
+			if len(args) == 2 {
+				update := backend.Status{Name: args[0]}
+
+				switch args[1] {
+				case "healthy":
+					update.Healthy = true
+				case "unhealthy":
+					update.Healthy = false
+				default:
+					return fmt.Errorf("invalid health %q (must be healthy or unhealthy)", args[1])
+				}
+
+				if err := client.Post("/backends", update, &status); err != nil {
+					return err
+				}
+			} else if err := client.Get("/backends", &status); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 8, 8, 2, ' ', 0)
+			fmt.Fprintf(w, "NAME\tKIND\tADDRESS\tHEALTHY\n")
+
+			for _, s := range status {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", s.Name, s.Kind, s.Address, s.Healthy)
+			}
+
+			return w.Flush()
+		},
+	}
+}
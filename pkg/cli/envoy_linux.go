@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package cli
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// startEnvoy starts the Envoy command. File capabilities aren't
+// inherited across execve, so if envoy-bootstrap is permitted to use
+// CAP_NET_ADMIN (e.g. for transparent listeners), Envoy gets it as an
+// ambient capability.
+func startEnvoy(cmd *exec.Cmd) error {
+	// Capabilities belong to threads, so Envoy has to be started
+	// from the thread whose inheritable set we raise.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return err
+	}
+
+	if data[0].Permitted&(1<<unix.CAP_NET_ADMIN) == 0 {
+		return cmd.Start()
+	}
+
+	data[0].Inheritable |= 1 << unix.CAP_NET_ADMIN
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.AmbientCaps = append(cmd.SysProcAttr.AmbientCaps, unix.CAP_NET_ADMIN)
+
+	return cmd.Start()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package cli
+
+import (
+	"os/exec"
+)
+
+// startEnvoy starts the Envoy command.
+func startEnvoy(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
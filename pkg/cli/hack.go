@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/hacks"
+
+	"github.com/spf13/cobra"
+)
+
+// NewHackCommand returns a "hack" subcommand.
+func NewHackCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hack",
+		Short: "Inspect the available hacks",
+	}
+
+	cmd.AddCommand(
+		Defaults(NewHackListCommand()),
+		Defaults(NewHackDescribeCommand()),
+	)
+
+	return cmd
+}
+
+// registerTemplateHacks registers the hacks defined by the template
+// files in the hack template directory.
+func registerTemplateHacks() error {
+	dir, err := hacks.TemplateDir()
+	if err != nil {
+		return err
+	}
+
+	return hacks.RegisterTemplates(dir)
+}
+
+// NewHackListCommand ...
+func NewHackListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the available hacks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := registerTemplateHacks(); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 8, 8, 2, ' ', 0)
+
+			for _, h := range hacks.Registered() {
+				fmt.Fprintf(w, "%s\t%s\n", h.Name(), h.Description())
+			}
+
+			for _, h := range hacks.Plugins() {
+				fmt.Fprintf(w, "%s\t%s\n", h.Name(), h.Description())
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+// NewHackDescribeCommand ...
+func NewHackDescribeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe NAME",
+		Short: "Describe the parameters of a hack",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := registerTemplateHacks(); err != nil {
+				return err
+			}
+
+			h, ok := hacks.Lookup(args[0])
+			if !ok {
+				return fmt.Errorf("no hack named %q", args[0])
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "%s - %s\n\n", h.Name(), h.Description())
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 8, 8, 2, ' ', 0)
+			fmt.Fprintf(w, "PARAMETER\tTYPE\tREQUIRED\tDEFAULT\tDESCRIPTION\n")
+
+			for _, p := range h.Parameters() {
+				required := "no"
+				if p.Required {
+					required = "yes"
+				}
+
+				def := p.Default
+				if def == "" {
+					def = "-"
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Name, p.Type, required, def, p.Help)
+			}
+
+			return w.Flush()
+		},
+	}
+}
@@ -1,18 +1,37 @@
 package cli
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jpeach/envoy-bootstrap/pkg/accesslog"
+	"github.com/jpeach/envoy-bootstrap/pkg/admin"
+	"github.com/jpeach/envoy-bootstrap/pkg/authz"
+	"github.com/jpeach/envoy-bootstrap/pkg/backend"
 	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/control"
+	"github.com/jpeach/envoy-bootstrap/pkg/endpoints"
 	"github.com/jpeach/envoy-bootstrap/pkg/hacks"
+	"github.com/jpeach/envoy-bootstrap/pkg/loadstats"
+	"github.com/jpeach/envoy-bootstrap/pkg/metrics"
 	"github.com/jpeach/envoy-bootstrap/pkg/must"
+	"github.com/jpeach/envoy-bootstrap/pkg/ratelimit"
 	"github.com/jpeach/envoy-bootstrap/pkg/xds"
 
 	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
@@ -36,6 +55,19 @@ func NewRunCommand() *cobra.Command {
 	}
 
 	run.Flags().StringArray("hack", []string{}, "Hack workload specification")
+	run.Flags().StringArray("hack-file", []string{}, "YAML file of hack workload specifications")
+	run.Flags().StringArray("backend", []string{}, "Built-in upstream backend (KIND[:name=NAME,address=IP,port=PORT])")
+	run.Flags().StringArray("endpoints", []string{}, "EDS cluster endpoints (NAME=HOST:PORT[,HOST:PORT...])")
+	run.Flags().StringArray("endpoints-file", []string{}, "YAML file of EDS cluster endpoints")
+	run.Flags().Duration("endpoints-interval", endpoints.DefaultInterval, "Interval for re-resolving EDS cluster endpoints")
+	run.Flags().StringArray("resolve", []string{}, "Resolve DNS endpoints statically (HOST=ADDR[,ADDR...])")
+	run.Flags().Duration("load-report-interval", loadstats.DefaultInterval, "Interval for Envoy load reports")
+	run.Flags().String("access-log", "", `Access logging for hack listeners ("grpc" or "")`)
+	run.Flags().String("access-log-file", "", "Write gRPC access log entries to this file instead of stdout")
+	run.Flags().Duration("stats-flush-interval", metrics.DefaultFlushInterval, "Interval for Envoy to flush stats to the metrics service")
+	run.Flags().StringArray("ratelimit-config", []string{}, "YAML rate limit configuration for the rate limit service")
+	run.Flags().String("authz-rules", "", "YAML rules for the external authorization service")
+	run.Flags().String("stats-summary", `^listener\..*\.downstream_cx_total$`, "Regular expression of stats to report when Envoy exits")
 
 	return Defaults(&run)
 }
@@ -44,39 +76,160 @@ type runState struct {
 	grpcServer *grpc.Server
 	xdsServer  xds.Server
 	snapshots  xds.SnapshotCache
+	publisher  *xds.Publisher
+	acks       *xds.AckTracker
+	control    *control.Server
+	loads      *loadstats.Server
+	accessLogs *accesslog.Server
+	metrics    *metrics.Server
+	rateLimits *ratelimit.Server
+	authz      *authz.Server
+
+	// connect is called with the node of each xDS request that
+	// carries one, so that we can build resources for the node. If
+	// it fails, the request fails.
+	connect func(node *bootstrap.Node) error
+
+	handlerLock sync.Mutex
+	handlers    map[string][]func(*http.Request) (interface{}, error)
 }
 
-func newServer() *runState {
-	run := runState{}
+// serverOptions configures the services that are served alongside xDS.
+type serverOptions struct {
+	loadReportInterval time.Duration
+	accessLogOutput    io.Writer
+	rateLimitConfigs   []*ratelimit.Config
+	authzRules         *authz.Rules
+}
+
+func newServer(opts serverOptions) *runState {
+	run := runState{
+		acks:     xds.NewAckTracker(),
+		handlers: map[string][]func(*http.Request) (interface{}, error){},
+	}
+
 	callbacks := xds.CallbackFuncs{
 		StreamOpenFunc: func(ctx context.Context, streamID int64, typeURL string) error {
 			log.Printf("[%d] opened stream for %q", streamID, typeURL)
 			return nil
 		},
+		StreamClosedFunc: func(streamID int64) {
+			run.acks.Closed(streamID)
+		},
 		StreamRequestFunc: func(streamID int64, request *envoy_service_discovery_v3.DiscoveryRequest) error {
 			log.Printf("[%d] requesting %s", streamID, request.GetTypeUrl())
 			log.Printf("[%d] wanted resources %s", streamID, request.GetResourceNames())
+			run.acks.Received(streamID, request)
+
+			if node := request.GetNode(); node != nil && run.connect != nil {
+				if err := run.connect(node); err != nil {
+					return err
+				}
+			}
+
 			return nil
 		},
 		StreamResponseFunc: func(streamID int64, request *envoy_service_discovery_v3.DiscoveryRequest, response *envoy_service_discovery_v3.DiscoveryResponse) {
 			if err := request.GetErrorDetail(); err != nil {
 				log.Printf("xDS error (code %d): %s", err.Code, err.Message)
 			}
+
+			run.acks.Sent(streamID, response)
 		},
 	}
 
 	options := []grpc.ServerOption{}
 	run.grpcServer = grpc.NewServer(options...)
 
-	// NOTE(jpeach): we use ConstantHash so that we server all nodes the same resources.
-	run.snapshots = xds.NewSnapshotCache(xds.ConstantHash("*"), &xds.StandardLogger{})
+	// NOTE(jpeach): we key snapshots by node ID so that hacks can
+	// build resources that suit the node that requests them.
+	run.snapshots = xds.NewSnapshotCache(xds.IDHash{}, &xds.StandardLogger{})
 	run.xdsServer = xds.NewServer(context.Background(), run.snapshots, callbacks)
+	run.publisher = xds.NewPublisher(run.snapshots)
 
 	xds.RegisterServer(run.grpcServer, run.xdsServer)
 
+	run.loads = loadstats.NewServer(opts.loadReportInterval)
+	run.loads.Register(run.grpcServer)
+
+	run.accessLogs = accesslog.NewServer(opts.accessLogOutput)
+	run.accessLogs.Register(run.grpcServer)
+
+	run.metrics = metrics.NewServer()
+	run.metrics.Register(run.grpcServer)
+
+	run.rateLimits = ratelimit.NewServer(opts.rateLimitConfigs...)
+	run.rateLimits.Register(run.grpcServer)
+
+	run.authz = authz.NewServer(opts.authzRules)
+	run.authz.Register(run.grpcServer)
+
+	run.control = control.NewServer()
+	run.control.HandleJSON("/load", func(*http.Request) (interface{}, error) {
+		return run.loads.Stats(), nil
+	})
+	run.control.HandleJSON("/stats", func(r *http.Request) (interface{}, error) {
+		var filter *regexp.Regexp
+
+		if f := r.URL.Query().Get("filter"); f != "" {
+			var err error
+			if filter, err = regexp.Compile(f); err != nil {
+				return nil, err
+			}
+		}
+
+		return run.metrics.Stats(filter), nil
+	})
+	run.control.HandleJSON("/ratelimit", func(*http.Request) (interface{}, error) {
+		return run.rateLimits.Counts(), nil
+	})
+	run.control.HandleJSON("/acks", func(*http.Request) (interface{}, error) {
+		return run.acks.Stats(), nil
+	})
+
 	return &run
 }
 
+// handleNodeJSON registers a hack control endpoint for a node. Since
+// each node builds its own hacks, a request to the endpoint is passed
+// to the handler of each node. The result is the value from the last
+// node, or the first error.
+func (run *runState) handleNodeJSON(path string, f func(*http.Request) (interface{}, error)) {
+	run.handlerLock.Lock()
+	defer run.handlerLock.Unlock()
+
+	if _, ok := run.handlers[path]; !ok {
+		run.control.HandleJSON(path, func(r *http.Request) (interface{}, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			run.handlerLock.Lock()
+			handlers := run.handlers[path]
+			run.handlerLock.Unlock()
+
+			var result interface{}
+
+			for _, h := range handlers {
+				req := r.Clone(r.Context())
+				req.Body = io.NopCloser(bytes.NewReader(body))
+
+				val, err := h(req)
+				if err != nil {
+					return nil, err
+				}
+
+				result = val
+			}
+
+			return result, nil
+		})
+	}
+
+	run.handlers[path] = append(run.handlers[path], f)
+}
+
 func writeProtobuf(path string, message proto.Message) error {
 	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
 	if err != nil {
@@ -116,18 +269,319 @@ func newManagementCluster(name string, addr *bootstrap.Address) *envoy_config_cl
 	}
 }
 
+// newEndpointSource returns an endpoint source configured from the
+// command line flags.
+func newEndpointSource(cmd *cobra.Command) (*endpoints.Source, error) {
+	source := endpoints.Source{
+		Files:    must.StringSlice(cmd.Flags().GetStringArray("endpoints-file")),
+		Interval: must.Duration(cmd.Flags().GetDuration("endpoints-interval")),
+		Resolver: net.DefaultResolver,
+	}
+
+	for _, e := range must.StringSlice(cmd.Flags().GetStringArray("endpoints")) {
+		c, err := endpoints.ParseCluster(e)
+		if err != nil {
+			return nil, err
+		}
+
+		source.Clusters = append(source.Clusters, c)
+	}
+
+	for _, path := range source.Files {
+		if _, err := endpoints.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if resolve := must.StringSlice(cmd.Flags().GetStringArray("resolve")); len(resolve) > 0 {
+		resolver := &endpoints.StaticResolver{Fallback: net.DefaultResolver}
+
+		for _, r := range resolve {
+			host, addrs, err := endpoints.ParseResolve(r)
+			if err != nil {
+				return nil, err
+			}
+
+			resolver.Add(host, addrs...)
+		}
+
+		source.Resolver = resolver
+	}
+
+	return &source, nil
+}
+
+// backendParameters is the parameter schema of each backend kind.
+var backendParameters = map[string][]hacks.ParameterSchema{
+	"http": {
+		{Name: "name", Type: hacks.StringParameter, Help: "Cluster name (defaults to backend/KIND)"},
+		{Name: "address", Type: hacks.IPParameter, Help: "Listen IP address, if a port is given"},
+		{Name: "port", Type: hacks.PortParameter, Help: "Listen port (listens on a unix socket if not set)"},
+	},
+	"tcp": {
+		{Name: "name", Type: hacks.StringParameter, Help: "Cluster name (defaults to backend/KIND)"},
+		{Name: "address", Type: hacks.IPParameter, Help: "Listen IP address, if a port is given"},
+		{Name: "port", Type: hacks.PortParameter, Help: "Listen port (listens on a unix socket if not set)"},
+	},
+	"grpc": {
+		{Name: "name", Type: hacks.StringParameter, Help: "Cluster name (defaults to backend/KIND)"},
+		{Name: "address", Type: hacks.IPParameter, Help: "Listen IP address, if a port is given"},
+		{Name: "port", Type: hacks.PortParameter, Help: "Listen port (listens on a unix socket if not set)"},
+	},
+	"udp": {
+		{Name: "name", Type: hacks.StringParameter, Help: "Cluster name (defaults to backend/KIND)"},
+		{Name: "address", Type: hacks.IPParameter, Help: "Listen IP address, if a port is given"},
+		{Name: "port", Type: hacks.PortParameter, Help: "Listen port (chosen by the kernel if not set)"},
+	},
+}
+
+// newBackends starts the built-in backends given by the command line
+// flags. Backends listen on a unix socket in the run directory, unless
+// a port is given. UDP backends always listen on a port.
+func newBackends(cmd *cobra.Command, runDir string) ([]*backend.Backend, error) {
+	var backends []*backend.Backend
+
+	names := map[string]bool{}
+
+	for n, b := range must.StringSlice(cmd.Flags().GetStringArray("backend")) {
+		spec, err := hacks.ParseSpec(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend spec %q: %w", b, err)
+		}
+
+		if err := spec.ReadFileRefs(""); err != nil {
+			return nil, fmt.Errorf("invalid backend spec %q: %w", b, err)
+		}
+
+		schema, ok := backendParameters[spec.Hack]
+		if !ok {
+			return nil, fmt.Errorf("invalid backend spec %q: unknown backend kind %q", b, spec.Hack)
+		}
+
+		if err := hacks.Validate(schema, spec); err != nil {
+			return nil, fmt.Errorf("invalid backend spec %q: %w", b, err)
+		}
+
+		name := must.String(spec.Parameters["name"].Or("backend/" + spec.Hack).AsString())
+		if names[name] {
+			return nil, fmt.Errorf("invalid backend spec %q: duplicate name %q", b, name)
+		}
+
+		names[name] = true
+
+		network := "unix"
+		address := path.Join(runDir, fmt.Sprintf("backend.%d.sock", n))
+
+		// Envoy can't proxy UDP to a unix socket, so UDP backends
+		// listen on a local port, which is chosen by the kernel
+		// unless a port is given.
+		if spec.Hack == "udp" {
+			network = "udp"
+			address = "127.0.0.1:0"
+		}
+
+		if !spec.Parameters["port"].IsZero() {
+			port, err := spec.Parameters["port"].AsInt64()
+			if err != nil {
+				return nil, fmt.Errorf("invalid backend spec %q: %w", b, err)
+			}
+
+			ip, err := spec.Parameters["address"].Or("127.0.0.1").IP()
+			if err != nil {
+				return nil, fmt.Errorf("invalid backend spec %q: %w", b, err)
+			}
+
+			if network != "udp" {
+				network = "tcp"
+			}
+
+			address = net.JoinHostPort(ip.String(), strconv.FormatInt(port, 10))
+		}
+
+		be, err := backend.Listen(spec.Hack, name, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		backends = append(backends, be)
+	}
+
+	return backends, nil
+}
+
+// hackSnapshot is the snapshot built from a hack spec, the
+// publisher source name it is published under, and any background
+// tasks and control endpoints that update it.
+type hackSnapshot struct {
+	source   string
+	snap     xds.Snapshot
+	tasks    []hacks.Task
+	handlers map[string]func(*http.Request) (interface{}, error)
+}
+
+// hackSpec is a parsed hack spec, along with where it came from so
+// that errors can point at the offending argument or file entry.
+type hackSpec struct {
+	origin string
+	spec   hacks.Spec
+}
+
+// buildHacks parses, validates and builds the hacks given by each
+// "--hack" argument and "--hack-file". Rather than stopping at the
+// first problem, it reports the errors for all the hacks together.
+func buildHacks(cmd *cobra.Command, env hacks.Env, accessLogMode string) ([]hackSnapshot, error) {
+	var specs []hackSpec
+	var problems []string
+
+	report := func(origin string, err error) {
+		var v *hacks.ValidationError
+		if !errors.As(err, &v) {
+			problems = append(problems, fmt.Sprintf("%s: %s", origin, err))
+			return
+		}
+
+		for _, err := range v.Errors {
+			problems = append(problems, fmt.Sprintf("%s: %s", origin, err))
+		}
+	}
+
+	for _, h := range must.StringSlice(cmd.Flags().GetStringArray("hack")) {
+		origin := fmt.Sprintf("--hack %q", h)
+
+		spec, err := hacks.ParseSpec(h)
+		if err != nil {
+			report(origin, err)
+			continue
+		}
+
+		if err := spec.ReadFileRefs(""); err != nil {
+			report(origin, err)
+			continue
+		}
+
+		specs = append(specs, hackSpec{origin: origin, spec: spec})
+	}
+
+	for _, path := range must.StringSlice(cmd.Flags().GetStringArray("hack-file")) {
+		fileSpecs, err := hacks.ReadSpecFile(path)
+		if err != nil {
+			report(fmt.Sprintf("--hack-file %q", path), err)
+			continue
+		}
+
+		for i, spec := range fileSpecs {
+			specs = append(specs, hackSpec{
+				origin: fmt.Sprintf("--hack-file %q entry %d (%s)", path, i, spec.Hack),
+				spec:   spec,
+			})
+		}
+	}
+
+	var snapshots []hackSnapshot
+
+	handlerPaths := map[string]string{}
+
+	for n, h := range specs {
+		hack, ok := hacks.Lookup(h.spec.Hack)
+		if !ok {
+			report(h.origin, fmt.Errorf("no hack named %q", h.spec.Hack))
+			continue
+		}
+
+		var tasks []hacks.Task
+		var handlerErrs []error
+
+		handlers := map[string]func(*http.Request) (interface{}, error){}
+
+		hackEnv := env
+		hackEnv.Go = func(t hacks.Task) {
+			tasks = append(tasks, t)
+		}
+		hackEnv.Handle = func(path string, f func(*http.Request) (interface{}, error)) {
+			if other, ok := handlerPaths[path]; ok {
+				handlerErrs = append(handlerErrs,
+					fmt.Errorf("control endpoint %q is already used by %s", path, other))
+				return
+			}
+
+			handlerPaths[path] = h.origin
+			handlers[path] = f
+		}
+
+		snap, err := hack.Build(hackEnv, h.spec)
+		if err != nil {
+			report(h.origin, err)
+			continue
+		}
+
+		for _, err := range handlerErrs {
+			report(h.origin, err)
+		}
+
+		if accessLogMode == "grpc" {
+			if err := accesslog.Attach(&snap, "xds"); err != nil {
+				report(h.origin, err)
+				continue
+			}
+		}
+
+		snapshots = append(snapshots, hackSnapshot{
+			source:   fmt.Sprintf("hack/%d/%s", n, h.spec.Hack),
+			snap:     snap,
+			tasks:    tasks,
+			handlers: handlers,
+		})
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid hack specs:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return snapshots, nil
+}
+
 func runEnvoy(cmd *cobra.Command, args []string) error {
 	envoyPath := args[0]
 	envoyArgs := args[1:]
 
-	// Validate hack args up front.
-	for _, h := range must.StringSlice(cmd.Flags().GetStringArray("hack")) {
-		_, err := hacks.ParseSpec(h)
+	if err := registerTemplateHacks(); err != nil {
+		return err
+	}
+
+	endpointSource, err := newEndpointSource(cmd)
+	if err != nil {
+		return err
+	}
+
+	statsSummary, err := regexp.Compile(must.String(cmd.Flags().GetString("stats-summary")))
+	if err != nil {
+		return fmt.Errorf("invalid stats summary: %w", err)
+	}
+
+	var rateLimitConfigs []*ratelimit.Config
+	for _, path := range must.StringSlice(cmd.Flags().GetStringArray("ratelimit-config")) {
+		c, err := ratelimit.ReadConfig(path)
 		if err != nil {
-			return fmt.Errorf("invalid hack spec %q: %w", h, err)
+			return err
+		}
+
+		rateLimitConfigs = append(rateLimitConfigs, c)
+	}
+
+	var authzRules *authz.Rules
+	if path := must.String(cmd.Flags().GetString("authz-rules")); path != "" {
+		if authzRules, err = authz.ReadRules(path); err != nil {
+			return err
 		}
 	}
 
+	accessLogMode := must.String(cmd.Flags().GetString("access-log"))
+	switch accessLogMode {
+	case "", "grpc":
+	default:
+		return fmt.Errorf("invalid access log mode %q", accessLogMode)
+	}
+
 	if err := unix.Access(envoyPath, unix.R_OK|unix.X_OK); err != nil {
 		return fmt.Errorf("%s: %w", envoyPath, err)
 	}
@@ -139,6 +593,7 @@ func runEnvoy(cmd *cobra.Command, args []string) error {
 
 	bootstrapPath := path.Join(tmpDir, "bootstrap.conf")
 	xdsSocketPath := path.Join(tmpDir, "xds.sock")
+	controlSocketPath := path.Join(tmpDir, control.SocketName)
 
 	// TODO(jpeach): Move this into core code so that the `bootstrap` and `run` commands generate the same thing.
 	envoyBootstrap := bootstrap.NewBootstrap()
@@ -146,7 +601,7 @@ func runEnvoy(cmd *cobra.Command, args []string) error {
 	envoyBootstrap.Admin = &bootstrap.Admin{
 		AccessLogPath: "/dev/null",
 		Address: bootstrap.NewPipeAddress(&bootstrap.PipeAddress{
-			Path: path.Join(tmpDir, "admin.sock"),
+			Path: path.Join(tmpDir, admin.SocketName),
 			Mode: 0644,
 		}),
 	}
@@ -174,17 +629,190 @@ func runEnvoy(cmd *cobra.Command, args []string) error {
 	envoyBootstrap.DynamicResources.AdsConfig = bootstrap.NewApiConfigSource("xds").ApiConfigSource
 	envoyBootstrap.DynamicResources.AdsConfig.TransportApiVersion = envoy_config_core_v3.ApiVersion_V3
 
+	// Send load reports to the LRS server on the xDS socket.
+	envoyBootstrap.ClusterManager = &bootstrap.ClusterManager{
+		LoadStatsConfig: bootstrap.NewApiConfigSource("xds").ApiConfigSource,
+	}
+	envoyBootstrap.ClusterManager.LoadStatsConfig.TransportApiVersion = envoy_config_core_v3.ApiVersion_V3
+
+	// Stream stats to the metrics service on the xDS socket.
+	envoyBootstrap.StatsSinks = []*bootstrap.StatsSink{metrics.NewStatsSink("xds")}
+	envoyBootstrap.StatsFlushInterval = ptypes.DurationProto(
+		must.Duration(cmd.Flags().GetDuration("stats-flush-interval")))
+
+	// Tell the management server which platform Envoy runs on, so
+	// that hacks can set platform-specific listener options.
+	bootstrap.SetPlatform(envoyBootstrap.Node, bootstrap.Platform{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	})
+
+	// Build the hacks up front, so that we fail before launching
+	// anything. These snapshots are published when Envoy connects,
+	// so the hacks are only built again for other nodes.
+	envoyHacks, err := buildHacks(cmd, hacks.Env{RunDir: tmpDir, Node: envoyBootstrap.Node}, accessLogMode)
+	if err != nil {
+		return err
+	}
+
 	if err := writeProtobuf(bootstrapPath, bootstrap.ProtoV2(envoyBootstrap)); err != nil {
 		return err
 	}
 
+	backends, err := newBackends(cmd, tmpDir)
+	if err != nil {
+		return err
+	}
+
 	// Need to listen before starting envoy, since it will fail to start if the socket isn't there.
 	listener, err := net.Listen("unix", xdsSocketPath)
 	if err != nil {
 		return err
 	}
 
-	run := newServer()
+	controlListener, err := net.Listen("unix", controlSocketPath)
+	if err != nil {
+		return err
+	}
+
+	opts := serverOptions{
+		loadReportInterval: must.Duration(cmd.Flags().GetDuration("load-report-interval")),
+		accessLogOutput:    cmd.OutOrStdout(),
+		rateLimitConfigs:   rateLimitConfigs,
+		authzRules:         authzRules,
+	}
+
+	if accessLogPath := must.String(cmd.Flags().GetString("access-log-file")); accessLogPath != "" {
+		accessLogFile, err := os.OpenFile(accessLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		defer accessLogFile.Close()
+		opts.accessLogOutput = accessLogFile
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	run := newServer(opts)
+	run.control.HandleJSON("/backends", func(r *http.Request) (interface{}, error) {
+		if r.Method == http.MethodPost {
+			var s backend.Status
+			if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+				return nil, err
+			}
+
+			found := false
+			for _, b := range backends {
+				if b.Name == s.Name {
+					b.SetHealthy(s.Healthy)
+					found = true
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf("no backend named %q", s.Name)
+			}
+
+			if s.Healthy {
+				log.Printf("backend %q is now healthy", s.Name)
+			} else {
+				log.Printf("backend %q is now unhealthy", s.Name)
+			}
+		}
+
+		status := []backend.Status{}
+		for _, b := range backends {
+			status = append(status, b.Status())
+		}
+
+		return status, nil
+	})
+
+	// startHacks publishes the hacks for a node, and starts their
+	// background tasks and control endpoints. Envoy's own node gets
+	// the hacks that were built before it was launched.
+	startHacks := func(node *bootstrap.Node) error {
+		hackSnapshots := envoyHacks
+
+		if platform := bootstrap.NodePlatform(node); node.GetId() != envoyBootstrap.Node.GetId() ||
+			platform != bootstrap.NodePlatform(envoyBootstrap.Node) {
+			log.Printf("building hacks for node %q (platform %s/%s)", node.GetId(), platform.OS, platform.Arch)
+
+			built, err := buildHacks(cmd, hacks.Env{RunDir: tmpDir, Node: node}, accessLogMode)
+			if err != nil {
+				return err
+			}
+
+			hackSnapshots = built
+		}
+
+		sources := map[string]xds.Snapshot{}
+		for _, h := range hackSnapshots {
+			sources[h.source] = h.snap
+		}
+
+		if err := run.publisher.AddNode(node.GetId(), sources); err != nil {
+			return err
+		}
+
+		for _, h := range hackSnapshots {
+			for path, f := range h.handlers {
+				run.handleNodeJSON(path, f)
+			}
+
+			update := func(source string) func(xds.Snapshot) error {
+				return func(snap xds.Snapshot) error {
+					if accessLogMode == "grpc" {
+						if err := accesslog.Attach(&snap, "xds"); err != nil {
+							return err
+						}
+					}
+
+					return run.publisher.UpdateNode(node.GetId(), source, snap)
+				}
+			}(h.source)
+
+			for _, t := range h.tasks {
+				go t(ctx, update)
+			}
+		}
+
+		return nil
+	}
+
+	// nodeHacks is the result of starting the hacks for a node.
+	type nodeHacks struct {
+		once sync.Once
+		err  error
+	}
+
+	var nodeLock sync.Mutex
+	nodes := map[string]*nodeHacks{}
+
+	// Start the hacks for each node the first time that it makes
+	// an xDS request. This happens before the request is answered,
+	// so the node never sees a snapshot without its hacks. Only the
+	// requests from that node wait for its hacks to be built, and if
+	// they fail to build, every request from the node fails.
+	run.connect = func(node *bootstrap.Node) error {
+		nodeLock.Lock()
+		n, ok := nodes[node.GetId()]
+		if !ok {
+			n = &nodeHacks{}
+			nodes[node.GetId()] = n
+		}
+		nodeLock.Unlock()
+
+		n.once.Do(func() {
+			if n.err = startHacks(node); n.err != nil {
+				log.Printf("ERROR: node %q: %s", node.GetId(), n.err)
+			}
+		})
+
+		return n.err
+	}
 
 	go func() {
 		log.Printf("serving xDS on %s", xdsSocketPath)
@@ -193,6 +821,13 @@ func runEnvoy(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
+	go func() {
+		log.Printf("serving control requests on %s", controlSocketPath)
+		if err := run.control.Serve(controlListener); err != nil {
+			log.Fatalf("control server failed: %s", err)
+		}
+	}()
+
 	envoyCmd := exec.Cmd{
 		Path: envoyPath,
 		Args: func() []string {
@@ -206,37 +841,34 @@ func runEnvoy(cmd *cobra.Command, args []string) error {
 		Stderr: cmd.ErrOrStderr(),
 	}
 
-	if err := envoyCmd.Start(); err != nil {
+	if err := startEnvoy(&envoyCmd); err != nil {
 		log.Fatalf("%s", err)
 	}
 
-	hackNames := map[string]func(hacks.Spec) xds.Snapshot{
-		"tcpproxy": hacks.HackTCPProxy,
-		"lua":      hacks.HackLuaFilter,
-	}
+	go endpointSource.Run(ctx, "endpoints", run.publisher)
 
-	for _, h := range must.StringSlice(cmd.Flags().GetStringArray("hack")) {
-		spec, err := hacks.ParseSpec(h)
-		if err != nil {
-			return fmt.Errorf("invalid hack spec %q: %w", h, err)
-		}
+	for _, b := range backends {
+		go func(b *backend.Backend) {
+			if err := b.Serve(); err != nil {
+				log.Printf("backend %q failed: %s", b.Name, err)
+			}
+		}(b)
+	}
 
-		var snap xds.Snapshot
+	if err := run.publisher.Update("backends", backend.Snapshot(backends)); err != nil {
+		log.Printf("ERROR: %s", err)
+	}
 
-		if h, ok := hackNames[spec.Hack]; ok {
-			snap = h(spec)
-		} else {
-			log.Printf("invalid hack spec %q: not found", spec.Hack)
-		}
+	envoyErr := envoyCmd.Wait()
 
-		// NOTE(jpeach): The NodeID we pass here matches the ConstantHash value.
-		if err := run.snapshots.SetSnapshot("*", snap); err != nil {
-			log.Printf("ERROR: %s", err)
+	if statsSummary.String() != "" {
+		for _, stat := range run.metrics.Stats(statsSummary) {
+			log.Printf("%s: %v", stat.Name, stat.Value)
 		}
 	}
 
-	if err := envoyCmd.Wait(); err != nil {
-		log.Fatalf("envoy exited: %s", err)
+	if envoyErr != nil {
+		log.Fatalf("envoy exited: %s", envoyErr)
 	}
 
 	return nil
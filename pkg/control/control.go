@@ -0,0 +1,190 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SocketName is the name of the control socket in the run directory.
+const SocketName = "control.sock"
+
+// Server serves the control and debug endpoints of a running
+// envoy-bootstrap over HTTP. Each endpoint returns JSON, so the
+// server can be queried with "ctl" subcommands or with curl, e.g.
+//
+//	curl --unix-socket /tmp/bootstrap.1234/control.sock http://_/load
+//
+// Handlers can be registered while the server is serving, e.g. by
+// hacks that are built when a node connects.
+type Server struct {
+	mux   *http.ServeMux
+	lock  sync.Mutex
+	paths []string
+}
+
+// NewServer returns a new control Server. The root path lists the
+// registered endpoints.
+func NewServer() *Server {
+	s := &Server{
+		mux: http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.lock.Lock()
+		paths := append([]string(nil), s.paths...)
+		s.lock.Unlock()
+
+		sort.Strings(paths)
+		writeJSON(w, paths)
+	})
+
+	return s
+}
+
+// Handle registers a handler for the given path.
+func (s *Server) Handle(path string, h http.Handler) {
+	s.lock.Lock()
+	s.paths = append(s.paths, path)
+	s.lock.Unlock()
+
+	s.mux.Handle(path, h)
+}
+
+// HandleJSON registers a handler for the given path that responds
+// with the JSON encoding of the value returned by f.
+func (s *Server) HandleJSON(path string, f func(r *http.Request) (interface{}, error)) {
+	s.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		val, err := f(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, val)
+	}))
+}
+
+// Serve serves control requests on the given listener.
+func (s *Server) Serve(l net.Listener) error {
+	srv := http.Server{Handler: s.mux}
+	return srv.Serve(l)
+}
+
+func writeJSON(w http.ResponseWriter, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(val); err != nil {
+		log.Printf("failed to encode control response: %s", err)
+	}
+}
+
+// FindSocket returns the path to the control socket of the most
+// recently started envoy-bootstrap.
+func FindSocket() (string, error) {
+	matches, err := filepath.Glob(path.Join(os.TempDir(), "bootstrap.*", SocketName))
+	if err != nil {
+		return "", err
+	}
+
+	var newest string
+	var newestInfo os.FileInfo
+
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+
+		if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) {
+			newest, newestInfo = m, info
+		}
+	}
+
+	if newest == "" {
+		return "", fmt.Errorf("no control socket found in %s", os.TempDir())
+	}
+
+	return newest, nil
+}
+
+// Client makes requests to a control Server.
+type Client struct {
+	http http.Client
+}
+
+// NewClient returns a Client for the control socket at the given path.
+func NewClient(socketPath string) *Client {
+	return &Client{
+		http: http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Get requests the given path and decodes the JSON response into out.
+func (c *Client) Get(path string, out interface{}) error {
+	resp, err := c.http.Get("http://control" + path)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Post sends the JSON encoding of in to the given path and decodes
+// the JSON response into out.
+func (c *Client) Post(path string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Post("http://control"+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func responseError(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,241 @@
+package endpoints
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/ghodss/yaml"
+)
+
+// Attributes are the load balancing attributes of an endpoint.
+type Attributes struct {
+	Region   string `json:"region,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	SubZone  string `json:"subZone,omitempty"`
+	Priority uint32 `json:"priority,omitempty"`
+	Weight   uint32 `json:"weight,omitempty"`
+	Health   string `json:"health,omitempty"`
+}
+
+// Endpoint is a single upstream host address.
+type Endpoint struct {
+	Address string `json:"address"`
+	Port    uint32 `json:"port"`
+
+	Attributes
+}
+
+// DNSTarget is a hostname that is periodically resolved to a set
+// of endpoints. Each resolved address inherits the attributes of
+// the target.
+type DNSTarget struct {
+	Hostname string `json:"hostname"`
+	Port     uint32 `json:"port"`
+
+	Attributes
+}
+
+// Cluster is a named collection of endpoints.
+type Cluster struct {
+	Name      string      `json:"name"`
+	Endpoints []Endpoint  `json:"endpoints,omitempty"`
+	DNS       []DNSTarget `json:"dns,omitempty"`
+}
+
+// File is the format of an endpoints file. For example:
+//
+//	clusters:
+//	- name: backend
+//	  endpoints:
+//	  - address: 10.0.0.1
+//	    port: 8080
+//	    zone: us-east-1a
+//	    weight: 10
+//	  - address: 10.0.0.2
+//	    port: 8080
+//	    priority: 1
+//	    health: DRAINING
+//	  dns:
+//	  - hostname: backend.example.com
+//	    port: 8080
+type File struct {
+	Clusters []Cluster `json:"clusters"`
+}
+
+// ReadFile reads a YAML endpoints file.
+func ReadFile(path string) ([]Cluster, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, c := range f.Clusters {
+		if c.Name == "" {
+			return nil, fmt.Errorf("%s: unnamed cluster", path)
+		}
+	}
+
+	return f.Clusters, nil
+}
+
+// ParseHostPort parses a "HOST:PORT" string.
+func ParseHostPort(s string) (string, uint32, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", 0, err
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q", s)
+	}
+
+	return host, uint32(p), nil
+}
+
+// ParseCluster parses a "NAME=HOST:PORT[,HOST:PORT...]" cluster
+// string. Hosts that are IP addresses become static endpoints, and
+// anything else becomes a DNS target.
+func ParseCluster(s string) (Cluster, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Cluster{}, fmt.Errorf("invalid cluster %q", s)
+	}
+
+	c := Cluster{Name: parts[0]}
+
+	for _, hostport := range strings.Split(parts[1], ",") {
+		host, port, err := ParseHostPort(hostport)
+		if err != nil {
+			return Cluster{}, err
+		}
+
+		if net.ParseIP(host) != nil {
+			c.Endpoints = append(c.Endpoints, Endpoint{Address: host, Port: port})
+		} else {
+			c.DNS = append(c.DNS, DNSTarget{Hostname: host, Port: port})
+		}
+	}
+
+	return c, nil
+}
+
+// healthStatus converts a health status name to the Envoy enum.
+func healthStatus(name string) (bootstrap.HealthStatus, error) {
+	if name == "" {
+		return envoy_config_core_v3.HealthStatus_UNKNOWN, nil
+	}
+
+	val, ok := envoy_config_core_v3.HealthStatus_value[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("invalid health status %q", name)
+	}
+
+	return bootstrap.HealthStatus(val), nil
+}
+
+// NewLoadAssignment builds a ClusterLoadAssignment for the named
+// cluster. Endpoints are grouped by locality and priority, and the
+// groups are sorted so that the result is stable for a given set
+// of endpoints.
+func NewLoadAssignment(name string, endpoints []Endpoint) (*bootstrap.ClusterLoadAssignment, error) {
+	type group struct {
+		region   string
+		zone     string
+		subZone  string
+		priority uint32
+	}
+
+	groups := map[group][]*bootstrap.LbEndpoint{}
+
+	sorted := append([]Endpoint(nil), endpoints...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Address != sorted[j].Address {
+			return sorted[i].Address < sorted[j].Address
+		}
+
+		return sorted[i].Port < sorted[j].Port
+	})
+
+	for _, e := range sorted {
+		if net.ParseIP(e.Address) == nil {
+			return nil, fmt.Errorf("cluster %q: invalid endpoint address %q", name, e.Address)
+		}
+
+		health, err := healthStatus(e.Health)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", name, err)
+		}
+
+		lb := bootstrap.NewLbEndpoint(bootstrap.NewSocketAddress(&bootstrap.SocketAddress{
+			Protocol:      bootstrap.TCP,
+			Address:       e.Address,
+			PortSpecifier: bootstrap.NewPortValue(e.Port),
+		}))
+
+		lb.HealthStatus = health
+
+		if e.Weight > 0 {
+			lb.LoadBalancingWeight = bootstrap.UInt32(e.Weight)
+		}
+
+		g := group{
+			region:   e.Region,
+			zone:     e.Zone,
+			subZone:  e.SubZone,
+			priority: e.Priority,
+		}
+
+		groups[g] = append(groups[g], lb)
+	}
+
+	keys := make([]group, 0, len(groups))
+	for g := range groups {
+		keys = append(keys, g)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+
+		if a.region != b.region {
+			return a.region < b.region
+		}
+
+		if a.zone != b.zone {
+			return a.zone < b.zone
+		}
+
+		return a.subZone < b.subZone
+	})
+
+	cla := &bootstrap.ClusterLoadAssignment{ClusterName: name}
+
+	for _, g := range keys {
+		cla.Endpoints = append(cla.Endpoints, &bootstrap.LocalityLbEndpoints{
+			Locality: &bootstrap.Locality{
+				Region:  g.region,
+				Zone:    g.zone,
+				SubZone: g.subZone,
+			},
+			Priority:    g.priority,
+			LbEndpoints: groups[g],
+		})
+	}
+
+	return cla, nil
+}
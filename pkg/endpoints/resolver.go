@@ -0,0 +1,66 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resolver resolves hostnames to addresses.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+var _ Resolver = &net.Resolver{}
+
+// StaticResolver is a Resolver that answers from a fixed table of
+// hosts. It stands in for DNS so that endpoint sources can be tested
+// offline. Hosts that are not in the table are passed to the Fallback
+// resolver, if there is one.
+type StaticResolver struct {
+	Hosts    map[string][]string
+	Fallback Resolver
+}
+
+var _ Resolver = &StaticResolver{}
+
+// Add appends addresses for the given host.
+func (s *StaticResolver) Add(host string, addrs ...string) {
+	if s.Hosts == nil {
+		s.Hosts = map[string][]string{}
+	}
+
+	s.Hosts[host] = append(s.Hosts[host], addrs...)
+}
+
+// LookupHost implements Resolver.
+func (s *StaticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := s.Hosts[host]; ok {
+		return addrs, nil
+	}
+
+	if s.Fallback != nil {
+		return s.Fallback.LookupHost(ctx, host)
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// ParseResolve parses a "HOST=ADDR[,ADDR...]" string, in the style
+// of curl's --resolve option.
+func ParseResolve(s string) (string, []string, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", nil, fmt.Errorf("invalid host resolution %q", s)
+	}
+
+	addrs := strings.Split(parts[1], ",")
+	for _, a := range addrs {
+		if net.ParseIP(a) == nil {
+			return "", nil, fmt.Errorf("invalid IP address %q", a)
+		}
+	}
+
+	return parts[0], addrs, nil
+}
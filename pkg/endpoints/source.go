@@ -0,0 +1,227 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultInterval is the default interval at which endpoint files
+// are re-read and DNS targets are re-resolved.
+const DefaultInterval = time.Second * 30
+
+// Source publishes EDS clusters and their ClusterLoadAssignments
+// built from static endpoint lists, endpoint files and periodic DNS
+// resolution.
+type Source struct {
+	// Clusters is the set of statically configured clusters.
+	Clusters []Cluster
+
+	// Files is a list of endpoint files to (re)read.
+	Files []string
+
+	// Resolver resolves DNS targets.
+	Resolver Resolver
+
+	// Interval is the refresh interval.
+	Interval time.Duration
+
+	// resolved holds the last good addresses for each DNS target
+	// so that a transient resolution failure doesn't drop them.
+	resolved map[string][]string
+
+	// current is the last published set of load assignments.
+	current map[string]*bootstrap.ClusterLoadAssignment
+}
+
+// collect merges the static clusters with the clusters from each
+// endpoint file. It returns an error if any endpoint file can't be
+// read, since dropping its clusters would remove them from Envoy.
+func (s *Source) collect() (map[string]*Cluster, error) {
+	clusters := map[string]*Cluster{}
+
+	add := func(c Cluster) {
+		if existing, ok := clusters[c.Name]; ok {
+			existing.Endpoints = append(existing.Endpoints, c.Endpoints...)
+			existing.DNS = append(existing.DNS, c.DNS...)
+			return
+		}
+
+		clusters[c.Name] = &Cluster{
+			Name:      c.Name,
+			Endpoints: append([]Endpoint(nil), c.Endpoints...),
+			DNS:       append([]DNSTarget(nil), c.DNS...),
+		}
+	}
+
+	for _, c := range s.Clusters {
+		add(c)
+	}
+
+	for _, path := range s.Files {
+		fileClusters, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range fileClusters {
+			add(c)
+		}
+	}
+
+	return clusters, nil
+}
+
+// resolve returns the endpoints for a DNS target.
+func (s *Source) resolve(ctx context.Context, target DNSTarget) []Endpoint {
+	if s.resolved == nil {
+		s.resolved = map[string][]string{}
+	}
+
+	addrs, err := s.Resolver.LookupHost(ctx, target.Hostname)
+	if err != nil {
+		log.Printf("failed to resolve %q: %s", target.Hostname, err)
+		addrs = s.resolved[target.Hostname]
+	} else {
+		s.resolved[target.Hostname] = addrs
+	}
+
+	var endpoints []Endpoint
+	for _, a := range addrs {
+		endpoints = append(endpoints, Endpoint{
+			Address:    a,
+			Port:       target.Port,
+			Attributes: target.Attributes,
+		})
+	}
+
+	return endpoints
+}
+
+// Build resolves all the configured endpoints and returns the load
+// assignment for each cluster. If an endpoint file can't be read, or
+// the endpoints of any cluster are invalid, Build returns an error
+// rather than dropping clusters.
+func (s *Source) Build(ctx context.Context) (map[string]*bootstrap.ClusterLoadAssignment, error) {
+	clusters, err := s.collect()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range clusters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	assignments := map[string]*bootstrap.ClusterLoadAssignment{}
+
+	for _, name := range names {
+		endpoints := clusters[name].Endpoints
+		for _, target := range clusters[name].DNS {
+			endpoints = append(endpoints, s.resolve(ctx, target)...)
+		}
+
+		cla, err := NewLoadAssignment(name, endpoints)
+		if err != nil {
+			return nil, err
+		}
+
+		assignments[name] = cla
+	}
+
+	return assignments, nil
+}
+
+// Snapshot returns a snapshot containing an EDS cluster and load
+// assignment for each cluster in the given assignments.
+func Snapshot(assignments map[string]*bootstrap.ClusterLoadAssignment) xds.Snapshot {
+	var names []string
+	for name := range assignments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var clusters []protov1.Message
+	var endpoints []protov1.Message
+
+	for _, name := range names {
+		clusters = append(clusters, bootstrap.NewEdsCluster(name))
+		endpoints = append(endpoints, assignments[name])
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ClusterType] = xds.NewResources("", clusters...)
+	snap.Resources[xds.EndpointType] = xds.NewResources("", endpoints...)
+
+	return snap
+}
+
+// changed returns true if the given assignments differ from the
+// currently published assignments.
+func (s *Source) changed(assignments map[string]*bootstrap.ClusterLoadAssignment) bool {
+	if s.current == nil || len(assignments) != len(s.current) {
+		return true
+	}
+
+	for name, cla := range assignments {
+		if !proto.Equal(cla, s.current[name]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run publishes the endpoints to the publisher under the given
+// source name, and republishes whenever they change. If the endpoints
+// fail to build, the last published endpoints are kept. Run returns
+// when the context is done.
+func (s *Source) Run(ctx context.Context, source string, pub *xds.Publisher) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		assignments, err := s.Build(ctx)
+
+		switch {
+		case err != nil:
+			log.Printf("failed to build endpoints: %s", err)
+		case s.changed(assignments):
+			for name, cla := range assignments {
+				count := 0
+				for _, l := range cla.Endpoints {
+					count += len(l.LbEndpoints)
+				}
+
+				log.Printf("cluster %q has %d endpoints", name, count)
+			}
+
+			if err := pub.Update(source, Snapshot(assignments)); err != nil {
+				log.Printf("failed to publish endpoints: %s", err)
+			}
+
+			s.current = assignments
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
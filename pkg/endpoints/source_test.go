@@ -0,0 +1,215 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+)
+
+func TestStaticResolver(t *testing.T) {
+	fallback := &StaticResolver{}
+	fallback.Add("fallback.example.com", "192.0.2.10")
+
+	r := &StaticResolver{Fallback: fallback}
+	r.Add("static.example.com", "192.0.2.1")
+	r.Add("static.example.com", "192.0.2.2")
+
+	for _, tc := range []struct {
+		host string
+		want []string
+	}{
+		{host: "static.example.com", want: []string{"192.0.2.1", "192.0.2.2"}},
+		{host: "fallback.example.com", want: []string{"192.0.2.10"}},
+	} {
+		addrs, err := r.LookupHost(context.Background(), tc.host)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.host, err)
+		}
+
+		if !reflect.DeepEqual(addrs, tc.want) {
+			t.Fatalf("%s: got %q, want %q", tc.host, addrs, tc.want)
+		}
+	}
+
+	_, err := r.LookupHost(context.Background(), "missing.example.com")
+
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("got error %v, want not found", err)
+	}
+}
+
+// endpointAddresses returns the addresses of each endpoint in the
+// load assignments, by cluster.
+func endpointAddresses(s *Source) (map[string][]string, error) {
+	assignments, err := s.Build(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := map[string][]string{}
+	for name, cla := range assignments {
+		addrs[name] = []string{}
+		for _, l := range cla.Endpoints {
+			for _, lb := range l.LbEndpoints {
+				addrs[name] = append(addrs[name], lb.GetEndpoint().GetAddress().GetSocketAddress().GetAddress())
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
+func TestSourceBuild(t *testing.T) {
+	resolver := &StaticResolver{}
+	resolver.Add("web.example.com", "192.0.2.2", "192.0.2.1")
+
+	s := &Source{
+		Clusters: []Cluster{
+			{Name: "static", Endpoints: []Endpoint{{Address: "10.0.0.1", Port: 80}}},
+			{Name: "dns", DNS: []DNSTarget{{Hostname: "web.example.com", Port: 80}}},
+			{Name: "static", Endpoints: []Endpoint{{Address: "10.0.0.2", Port: 80}}},
+		},
+		Resolver: resolver,
+	}
+
+	want := map[string][]string{
+		"static": {"10.0.0.1", "10.0.0.2"},
+		"dns":    {"192.0.2.1", "192.0.2.2"},
+	}
+
+	addrs, err := endpointAddresses(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %q, want %q", addrs, want)
+	}
+
+	// A failed resolution keeps the last good addresses.
+	delete(resolver.Hosts, "web.example.com")
+
+	addrs, err = endpointAddresses(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("after failed resolution, got %q, want %q", addrs, want)
+	}
+}
+
+func TestSourceBuildInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cluster Cluster
+		want    string
+	}{
+		{
+			name:    "address",
+			cluster: Cluster{Name: "bad", Endpoints: []Endpoint{{Address: "not-an-ip", Port: 80}}},
+			want:    `cluster "bad": invalid endpoint address "not-an-ip"`,
+		},
+		{
+			name: "health",
+			cluster: Cluster{Name: "bad", Endpoints: []Endpoint{
+				{Address: "10.0.0.1", Port: 80, Attributes: Attributes{Health: "sick"}},
+			}},
+			want: `cluster "bad": invalid health status "sick"`,
+		},
+	} {
+		s := &Source{
+			Clusters: []Cluster{
+				{Name: "good", Endpoints: []Endpoint{{Address: "10.0.0.1", Port: 80}}},
+				tc.cluster,
+			},
+			Resolver: &StaticResolver{},
+		}
+
+		assignments, err := s.Build(context.Background())
+		if err == nil {
+			t.Fatalf("%s: got %d assignments, want error", tc.name, len(assignments))
+		}
+
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: got error %q, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// publishedClusters returns the names of the published clusters.
+func publishedClusters(pub *xds.Publisher) []string {
+	var names []string
+	for name := range pub.Snapshot("test").Resources[xds.ClusterType].Items {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func TestSourceRunUnreadableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	data := `
+clusters:
+- name: file
+  endpoints:
+  - address: 10.0.0.1
+    port: 80
+`
+
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Source{
+		Files:    []string{path},
+		Resolver: &StaticResolver{},
+		Interval: 10 * time.Millisecond,
+	}
+
+	pub := xds.NewPublisher(xds.NewSnapshotCache(xds.IDHash{}, &xds.StandardLogger{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		s.Run(ctx, "endpoints", pub)
+		close(done)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); publishedClusters(pub) == nil; {
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoints were not published")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give Run a few intervals to fail to read the file, then check
+	// that the last published endpoints are kept.
+	time.Sleep(100 * time.Millisecond)
+
+	want := []string{"file"}
+	if names := publishedClusters(pub); !reflect.DeepEqual(names, want) {
+		t.Fatalf("after removing the file, got clusters %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,459 @@
+package hacks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/endpoints"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// churnKinds are the kinds of resources that the churn hack can mutate.
+var churnKinds = []string{"listeners", "routes", "clusters", "endpoints"}
+
+func init() {
+	Register(New("churn",
+		"Listeners, routes, clusters and endpoints that are added, removed and modified on a schedule",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Default: "127.0.0.1", Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Default: "21000", Help: "Lowest listener port"},
+			{Name: "listeners", Type: IntParameter, Default: "2", Help: "Initial number of listeners"},
+			{Name: "routes", Type: IntParameter, Default: "4", Help: "Initial number of routes per listener"},
+			{Name: "clusters", Type: IntParameter, Default: "4", Help: "Initial number of clusters"},
+			{Name: "endpoints", Type: IntParameter, Default: "2", Help: "Initial number of endpoints per cluster"},
+			{Name: "endpoint_address", Type: IPParameter, Default: "127.0.0.1", Help: "IP address of the endpoints"},
+			{Name: "endpoint_port", Type: PortParameter, Default: "31000", Help: "Lowest endpoint port"},
+			{Name: "interval", Type: DurationParameter, Default: "10s", Help: "Interval between rounds of changes"},
+			{Name: "fraction", Type: FloatParameter, Default: "0.1", Help: "Fraction of each kind of resource to change in each round"},
+			{Name: "kinds", Type: ListParameter,
+				Help: "Kinds of resources to change (listeners, routes, clusters or endpoints; all if not set)"},
+			{Name: "rounds", Type: IntParameter, Default: "0", Help: "Number of rounds to run (0 for no limit)"},
+			{Name: "seed", Type: IntParameter, Default: "1", Help: "Random seed, so that runs can be repeated"},
+		},
+		HackChurn,
+	))
+}
+
+type churnRoute struct {
+	prefix  string
+	cluster string
+}
+
+type churnListener struct {
+	port       int64
+	generation int
+	routes     []churnRoute
+}
+
+type churnCluster struct {
+	name       string
+	generation int
+	ports      []uint32
+}
+
+// churnModel is the mutable state that the churn hack generates
+// resources from.
+type churnModel struct {
+	rand *rand.Rand
+
+	addr         net.IP
+	port         int64
+	endpointAddr net.IP
+	endpointPort int64
+	endpoints    int64
+
+	// next is used to generate unique names.
+	next int
+
+	listeners []*churnListener
+	clusters  []*churnCluster
+}
+
+// churnStats counts the changes made to a kind of resource in a round.
+type churnStats struct {
+	added    int
+	removed  int
+	modified int
+}
+
+func (s churnStats) String() string {
+	return fmt.Sprintf("+%d -%d ~%d", s.added, s.removed, s.modified)
+}
+
+func (m *churnModel) nextID() int {
+	m.next++
+	return m.next
+}
+
+// freePort returns the lowest unused listener port, or 0 if there
+// are none left.
+func (m *churnModel) freePort() int64 {
+	used := map[int64]bool{}
+	for _, l := range m.listeners {
+		used[l.port] = true
+	}
+
+	for port := m.port; port <= math.MaxUint16; port++ {
+		if !used[port] {
+			return port
+		}
+	}
+
+	return 0
+}
+
+// nextEndpointPort returns the next endpoint port, wrapping around at the
+// end of the port range.
+func (m *churnModel) nextEndpointPort() uint32 {
+	return uint32(m.endpointPort + int64(m.nextID())%(math.MaxUint16-m.endpointPort+1))
+}
+
+func (m *churnModel) randomCluster() string {
+	return m.clusters[m.rand.Intn(len(m.clusters))].name
+}
+
+func (m *churnModel) newRoute() churnRoute {
+	return churnRoute{
+		prefix:  fmt.Sprintf("/churn-%d", m.nextID()),
+		cluster: m.randomCluster(),
+	}
+}
+
+func (m *churnModel) addListener(routes int64) bool {
+	port := m.freePort()
+	if port == 0 {
+		return false
+	}
+
+	l := &churnListener{port: port}
+	for r := int64(0); r < routes; r++ {
+		l.routes = append(l.routes, m.newRoute())
+	}
+
+	m.listeners = append(m.listeners, l)
+	return true
+}
+
+func (m *churnModel) addCluster() {
+	c := &churnCluster{name: fmt.Sprintf("hack/churn/cluster/%d", m.nextID())}
+	for e := int64(0); e < m.endpoints; e++ {
+		c.ports = append(c.ports, m.nextEndpointPort())
+	}
+
+	m.clusters = append(m.clusters, c)
+}
+
+// removeCluster removes the cluster at the given index, and moves
+// the routes that use it to the remaining clusters.
+func (m *churnModel) removeCluster(i int) {
+	name := m.clusters[i].name
+	m.clusters = append(m.clusters[:i], m.clusters[i+1:]...)
+
+	for _, l := range m.listeners {
+		for r := range l.routes {
+			if l.routes[r].cluster == name {
+				l.routes[r].cluster = m.randomCluster()
+			}
+		}
+	}
+}
+
+// mutate changes n resources of the given kind. Each change is a
+// randomly chosen add, remove or modify. We never remove the last
+// resource of a kind, so that routes always have a cluster and
+// clusters always have an endpoint.
+func (m *churnModel) mutate(kind string, n int) churnStats {
+	stats := churnStats{}
+
+	for i := 0; i < n; i++ {
+		op := m.rand.Intn(3)
+
+		switch kind {
+		case "listeners":
+			switch {
+			case op == 0 && m.addListener(1):
+				stats.added++
+			case op == 1 && len(m.listeners) > 1:
+				j := m.rand.Intn(len(m.listeners))
+				m.listeners = append(m.listeners[:j], m.listeners[j+1:]...)
+				stats.removed++
+			default:
+				m.listeners[m.rand.Intn(len(m.listeners))].generation++
+				stats.modified++
+			}
+
+		case "routes":
+			l := m.listeners[m.rand.Intn(len(m.listeners))]
+
+			switch {
+			case op == 0:
+				l.routes = append(l.routes, m.newRoute())
+				stats.added++
+			case op == 1 && len(l.routes) > 1:
+				j := m.rand.Intn(len(l.routes))
+				l.routes = append(l.routes[:j], l.routes[j+1:]...)
+				stats.removed++
+			default:
+				l.routes[m.rand.Intn(len(l.routes))].cluster = m.randomCluster()
+				stats.modified++
+			}
+
+		case "clusters":
+			switch {
+			case op == 0:
+				m.addCluster()
+				stats.added++
+			case op == 1 && len(m.clusters) > 1:
+				m.removeCluster(m.rand.Intn(len(m.clusters)))
+				stats.removed++
+			default:
+				m.clusters[m.rand.Intn(len(m.clusters))].generation++
+				stats.modified++
+			}
+
+		case "endpoints":
+			c := m.clusters[m.rand.Intn(len(m.clusters))]
+
+			switch {
+			case op == 0:
+				c.ports = append(c.ports, m.nextEndpointPort())
+				stats.added++
+			case op == 1 && len(c.ports) > 1:
+				j := m.rand.Intn(len(c.ports))
+				c.ports = append(c.ports[:j], c.ports[j+1:]...)
+				stats.removed++
+			default:
+				c.ports[m.rand.Intn(len(c.ports))] = m.nextEndpointPort()
+				stats.modified++
+			}
+		}
+	}
+
+	return stats
+}
+
+// count returns the current number of resources of the given kind.
+func (m *churnModel) count(kind string) int {
+	n := 0
+
+	switch kind {
+	case "listeners":
+		n = len(m.listeners)
+	case "routes":
+		for _, l := range m.listeners {
+			n += len(l.routes)
+		}
+	case "clusters":
+		n = len(m.clusters)
+	case "endpoints":
+		for _, c := range m.clusters {
+			n += len(c.ports)
+		}
+	}
+
+	return n
+}
+
+// snapshot generates the resources for the current state of the model.
+func (m *churnModel) snapshot() (xds.Snapshot, error) {
+	var listeners []protov1.Message
+	var routeConfigs []protov1.Message
+	var clusters []protov1.Message
+	var assignments []protov1.Message
+
+	for _, l := range m.listeners {
+		listenerName := fmt.Sprintf("hack/churn/listener/%d", l.port)
+		routeName := fmt.Sprintf("hack/churn/route/%d", l.port)
+
+		// Changing the stat prefix changes the listener, so that
+		// Envoy has to drain and replace it.
+		listeners = append(listeners, NewTCPListener(listenerName, m.addr, l.port,
+			NewHTTPConnectionManager(fmt.Sprintf("hack-churn-%d-%d", l.port, l.generation), routeName)))
+
+		var routes []*bootstrap.Route
+		for _, r := range l.routes {
+			routes = append(routes, bootstrap.NewClusterRoute(r.prefix, r.cluster))
+		}
+
+		routeConfigs = append(routeConfigs, NewRouteConfiguration(routeName, routes...))
+	}
+
+	for _, c := range m.clusters {
+		cluster := bootstrap.NewEdsCluster(c.name)
+		cluster.ConnectTimeout = ptypes.DurationProto(time.Second * time.Duration(1+c.generation%10))
+		clusters = append(clusters, cluster)
+
+		var eps []endpoints.Endpoint
+		for _, port := range c.ports {
+			eps = append(eps, endpoints.Endpoint{Address: m.endpointAddr.String(), Port: port})
+		}
+
+		cla, err := endpoints.NewLoadAssignment(c.name, eps)
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		assignments = append(assignments, cla)
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listeners...)
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), routeConfigs...)
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+	snap.Resources[xds.EndpointType] = xds.NewResources(NewVersion(), assignments...)
+
+	return snap, nil
+}
+
+// HackChurn generates listeners, routes, clusters and endpoints, and
+// then changes a fraction of them in each round, publishing each
+// round as a new snapshot. The changes are pseudo-random, so the same
+// seed gives the same sequence of snapshots. Use "ctl acks" to see
+// how long Envoy takes to apply each round.
+func HackChurn(env Env, spec Spec) (xds.Snapshot, error) {
+	m := churnModel{}
+
+	var err error
+
+	if m.addr, err = spec.Parameters["address"].IP(); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if m.endpointAddr, err = spec.Parameters["endpoint_address"].IP(); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	counts := map[string]int64{}
+	for _, name := range []string{"port", "listeners", "routes", "clusters", "endpoints", "endpoint_port", "rounds", "seed"} {
+		if counts[name], err = spec.Parameters[name].AsInt64(); err != nil {
+			return xds.Snapshot{}, err
+		}
+	}
+
+	for _, name := range []string{"listeners", "routes", "clusters", "endpoints"} {
+		if counts[name] < 1 {
+			return xds.Snapshot{}, fmt.Errorf("parameter %q must be at least 1", name)
+		}
+	}
+
+	if counts["rounds"] < 0 {
+		return xds.Snapshot{}, fmt.Errorf("parameter %q must not be negative", "rounds")
+	}
+
+	if counts["port"]+counts["listeners"]-1 > math.MaxUint16 {
+		return xds.Snapshot{}, fmt.Errorf("%d listeners from port %d exceed the port range",
+			counts["listeners"], counts["port"])
+	}
+
+	interval, err := spec.Parameters["interval"].AsDuration()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if interval <= 0 {
+		return xds.Snapshot{}, fmt.Errorf("parameter %q must be positive", "interval")
+	}
+
+	fraction, err := spec.Parameters["fraction"].AsFloat64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if fraction <= 0 || fraction > 1 {
+		return xds.Snapshot{}, fmt.Errorf("parameter %q must be greater than 0 and at most 1", "fraction")
+	}
+
+	kindList, err := spec.Parameters["kinds"].AsList()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	valid := map[string]bool{}
+	for _, k := range churnKinds {
+		valid[k] = true
+	}
+
+	var kinds []string
+	for _, k := range kindList {
+		if !valid[k.Value] {
+			return xds.Snapshot{}, fmt.Errorf("invalid kind %q (must be one of %s)",
+				k.Value, strings.Join(churnKinds, ", "))
+		}
+
+		kinds = append(kinds, k.Value)
+	}
+
+	if len(kinds) == 0 {
+		kinds = churnKinds
+	}
+
+	m.rand = rand.New(rand.NewSource(counts["seed"]))
+	m.port = counts["port"]
+	m.endpointPort = counts["endpoint_port"]
+	m.endpoints = counts["endpoints"]
+
+	for c := int64(0); c < counts["clusters"]; c++ {
+		m.addCluster()
+	}
+
+	for l := int64(0); l < counts["listeners"]; l++ {
+		m.addListener(counts["routes"])
+	}
+
+	snap, err := m.snapshot()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if env.Go == nil {
+		return snap, nil
+	}
+
+	env.Go(func(ctx context.Context, update func(xds.Snapshot) error) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for round := int64(1); counts["rounds"] == 0 || round <= counts["rounds"]; round++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			var changes []string
+			for _, kind := range kinds {
+				n := int(math.Max(1, math.Round(fraction*float64(m.count(kind)))))
+				changes = append(changes, fmt.Sprintf("%s %s (%d)", kind, m.mutate(kind, n), m.count(kind)))
+			}
+
+			snap, err := m.snapshot()
+			if err == nil {
+				err = update(snap)
+			}
+
+			if err != nil {
+				log.Printf("churn: round %d: %s", round, err)
+				continue
+			}
+
+			size := 0
+			for _, t := range []xds.ResponseType{xds.ListenerType, xds.RouteType, xds.ClusterType, xds.EndpointType} {
+				size += snapshotSize(&snap, t)
+			}
+
+			log.Printf("churn: round %d: %s, %d bytes", round, strings.Join(changes, ", "), size)
+		}
+	})
+
+	return snap, nil
+}
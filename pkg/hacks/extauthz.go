@@ -0,0 +1,85 @@
+package hacks
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_http_ext_authz_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	"github.com/golang/protobuf/ptypes"
+)
+
+func init() {
+	Register(New("extauthz",
+		"HTTP listener that checks requests with the external authorization service",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "cluster", Type: StringParameter, Default: "xds", Help: "Authorization service gRPC cluster"},
+			{Name: "failopen", Type: BoolParameter, Default: "false", Help: "Allow requests if the authorization service fails"},
+			{Name: "upstream", Type: AddressParameter, Help: "Upstream address (requests get a direct response if not set)"},
+		},
+		HackExtAuthz,
+	))
+}
+
+// HackExtAuthz builds an HTTP listener that checks each request with
+// the external authorization service in the named gRPC cluster.
+// Requests are forwarded to the upstream address if there is one,
+// otherwise they get a direct response.
+func HackExtAuthz(_ Env, spec Spec) (xds.Snapshot, error) {
+	cluster := spec.Parameters["cluster"].Value
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	failOpen, err := spec.Parameters["failopen"].AsBool()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	listenerName := fmt.Sprintf("hack/extauthz/listener/%d", port)
+	routeName := fmt.Sprintf("hack/extauthz/route/%d", port)
+	upstreamName := fmt.Sprintf("hack/extauthz/cluster/%d", port)
+
+	authz := &envoy_extensions_filters_http_ext_authz_v3.ExtAuthz{
+		Services: &envoy_extensions_filters_http_ext_authz_v3.ExtAuthz_GrpcService{
+			GrpcService: bootstrap.NewGrpcService(cluster),
+		},
+		TransportApiVersion: envoy_config_core_v3.ApiVersion_V3,
+		FailureModeAllow:    failOpen,
+	}
+
+	authz.GetGrpcService().Timeout = ptypes.DurationProto(time.Second)
+
+	filter := bootstrap.NewHTTPFilter(
+		"envoy.filters.http.ext_authz",
+		bootstrap.ProtoV2(authz),
+	)
+
+	listener := NewTCPListener(listenerName, addr, port,
+		NewHTTPConnectionManager(strings.Replace(listenerName, "/", "-", -1), routeName, filter))
+
+	route, clusters, err := NewUpstreamRoute(spec, upstreamName)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), NewRouteConfiguration(routeName, route))
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+
+	return snap, nil
+}
@@ -0,0 +1,298 @@
+package hacks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_extensions_filters_common_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/common/fault/v3"
+	envoy_extensions_filters_http_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+)
+
+func init() {
+	Register(New("fault",
+		"HTTP listener with a fault filter that delays or aborts requests",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "delay", Type: DurationParameter, Help: "Fixed delay to add to requests"},
+			{Name: "delay_percent", Type: FloatParameter, Default: "100", Help: "Percentage of requests to delay"},
+			{Name: "abort_status", Type: IntParameter, Help: "HTTP status to abort requests with"},
+			{Name: "abort_grpc_status", Type: IntParameter, Help: "gRPC status to abort requests with"},
+			{Name: "abort_percent", Type: FloatParameter, Default: "100", Help: "Percentage of requests to abort"},
+			{Name: "header_controlled", Type: BoolParameter, Default: "false",
+				Help: "Take delays and aborts from the x-envoy-fault-delay-request and x-envoy-fault-abort-request headers"},
+			{Name: "max_active_faults", Type: IntParameter, Help: "Maximum number of requests with active faults"},
+			{Name: "cluster", Type: StringParameter, Help: "Cluster to route requests to, e.g. backend/http"},
+			{Name: "upstream", Type: AddressParameter, Help: "Upstream address to route requests to"},
+		},
+		HackFault,
+	))
+}
+
+// FaultPercentages are the fault percentages of a fault hack that
+// can be changed while it runs.
+type FaultPercentages struct {
+	DelayPercent float64 `json:"delayPercent"`
+	AbortPercent float64 `json:"abortPercent"`
+}
+
+// Validate checks that the percentages are in range.
+func (f FaultPercentages) Validate() error {
+	for name, p := range map[string]float64{"delay": f.DelayPercent, "abort": f.AbortPercent} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("invalid %s percentage %v (must be 0-100)", name, p)
+		}
+	}
+
+	return nil
+}
+
+// FaultControlPath returns the control endpoint path for the fault
+// hack listening on the given port.
+func FaultControlPath(port int64) string {
+	return fmt.Sprintf("/fault/%d", port)
+}
+
+// HackFault builds an HTTP listener with a fault filter. Requests are
+// delayed or aborted with fixed values, or with values taken from the
+// request headers, and then routed to the named cluster or upstream
+// address. Requests get a direct response if neither is given.
+//
+// The delay and abort percentages can be changed while Envoy runs
+// with "ctl fault PORT", which publishes a new listener.
+func HackFault(env Env, spec Spec) (xds.Snapshot, error) {
+	type HTTPFault = envoy_extensions_filters_http_fault_v3.HTTPFault
+	type FaultAbort = envoy_extensions_filters_http_fault_v3.FaultAbort
+	type FaultDelay = envoy_extensions_filters_common_fault_v3.FaultDelay
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	headerControlled, err := spec.Parameters["header_controlled"].AsBool()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	percentages := FaultPercentages{}
+
+	if percentages.DelayPercent, err = spec.Parameters["delay_percent"].AsFloat64(); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if percentages.AbortPercent, err = spec.Parameters["abort_percent"].AsFloat64(); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if err := percentages.Validate(); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	fault := HTTPFault{}
+
+	if !spec.Parameters["max_active_faults"].IsZero() {
+		max, err := spec.Parameters["max_active_faults"].AsInt64()
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		if max < 0 {
+			return xds.Snapshot{}, fmt.Errorf("parameter %q must not be negative", "max_active_faults")
+		}
+
+		fault.MaxActiveFaults = bootstrap.UInt32(uint32(max))
+	}
+
+	var delay *FaultDelay
+	var abort *FaultAbort
+
+	switch {
+	case !spec.Parameters["delay"].IsZero() && headerControlled:
+		return xds.Snapshot{}, fmt.Errorf("the delay and header_controlled parameters cannot be used together")
+	case !spec.Parameters["delay"].IsZero():
+		d, err := spec.Parameters["delay"].AsDuration()
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		delay = &FaultDelay{
+			FaultDelaySecifier: &envoy_extensions_filters_common_fault_v3.FaultDelay_FixedDelay{
+				FixedDelay: ptypes.DurationProto(d),
+			},
+		}
+	case headerControlled:
+		delay = &FaultDelay{
+			FaultDelaySecifier: &envoy_extensions_filters_common_fault_v3.FaultDelay_HeaderDelay_{
+				HeaderDelay: &envoy_extensions_filters_common_fault_v3.FaultDelay_HeaderDelay{},
+			},
+		}
+	}
+
+	switch {
+	case !spec.Parameters["abort_status"].IsZero() && !spec.Parameters["abort_grpc_status"].IsZero():
+		return xds.Snapshot{}, fmt.Errorf("the abort_status and abort_grpc_status parameters cannot be used together")
+	case (!spec.Parameters["abort_status"].IsZero() || !spec.Parameters["abort_grpc_status"].IsZero()) && headerControlled:
+		return xds.Snapshot{}, fmt.Errorf("the abort status and header_controlled parameters cannot be used together")
+	case !spec.Parameters["abort_status"].IsZero():
+		status, err := spec.Parameters["abort_status"].AsInt64()
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		if status < 200 || status > 599 {
+			return xds.Snapshot{}, fmt.Errorf("invalid abort status %d (must be 200-599)", status)
+		}
+
+		abort = &FaultAbort{
+			ErrorType: &envoy_extensions_filters_http_fault_v3.FaultAbort_HttpStatus{HttpStatus: uint32(status)},
+		}
+	case !spec.Parameters["abort_grpc_status"].IsZero():
+		status, err := spec.Parameters["abort_grpc_status"].AsInt64()
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		if status < 0 {
+			return xds.Snapshot{}, fmt.Errorf("invalid abort gRPC status %d", status)
+		}
+
+		abort = &FaultAbort{
+			ErrorType: &envoy_extensions_filters_http_fault_v3.FaultAbort_GrpcStatus{GrpcStatus: uint32(status)},
+		}
+	case headerControlled:
+		abort = &FaultAbort{
+			ErrorType: &envoy_extensions_filters_http_fault_v3.FaultAbort_HeaderAbort_{
+				HeaderAbort: &envoy_extensions_filters_http_fault_v3.FaultAbort_HeaderAbort{},
+			},
+		}
+	}
+
+	if delay == nil && abort == nil {
+		return xds.Snapshot{}, fmt.Errorf("at least one of delay, abort_status, abort_grpc_status or header_controlled is required")
+	}
+
+	listenerName := fmt.Sprintf("hack/fault/listener/%d", port)
+	routeName := fmt.Sprintf("hack/fault/route/%d", port)
+	upstreamName := fmt.Sprintf("hack/fault/cluster/%d", port)
+
+	// newListener returns the listener with the fault filter
+	// configured for the given percentages.
+	newListener := func(p FaultPercentages) *bootstrap.Listener {
+		config := protov1.Clone(&fault).(*HTTPFault)
+
+		if delay != nil {
+			config.Delay = protov1.Clone(delay).(*FaultDelay)
+			config.Delay.Percentage = bootstrap.NewPercent(p.DelayPercent)
+		}
+
+		if abort != nil {
+			config.Abort = protov1.Clone(abort).(*FaultAbort)
+			config.Abort.Percentage = bootstrap.NewPercent(p.AbortPercent)
+		}
+
+		return NewTCPListener(listenerName, addr, port,
+			NewHTTPConnectionManager(strings.Replace(listenerName, "/", "-", -1), routeName,
+				bootstrap.NewHTTPFilter("envoy.filters.http.fault", bootstrap.ProtoV2(config))))
+	}
+
+	action, clusters, err := NewRouteAction(spec, upstreamName)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	routes := NewRouteConfiguration(routeName, action("/"))
+
+	newSnapshot := func(p FaultPercentages) xds.Snapshot {
+		snap := xds.Snapshot{}
+		snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), newListener(p))
+		snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), routes)
+		snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+		return snap
+	}
+
+	if env.Go != nil {
+		startFaultControl(env, port, percentages, newSnapshot)
+	}
+
+	return newSnapshot(percentages), nil
+}
+
+// startFaultControl registers the control endpoint that changes the
+// fault percentages, and the task that publishes the changes.
+func startFaultControl(env Env, port int64, initial FaultPercentages, newSnapshot func(FaultPercentages) xds.Snapshot) {
+	type faultUpdate struct {
+		percentages FaultPercentages
+		done        chan error
+	}
+
+	var lock sync.Mutex
+	current := initial
+	updates := make(chan faultUpdate)
+
+	env.Go(func(ctx context.Context, update func(xds.Snapshot) error) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u := <-updates:
+				err := update(newSnapshot(u.percentages))
+				if err == nil {
+					lock.Lock()
+					current = u.percentages
+					lock.Unlock()
+
+					log.Printf("fault: port %d now delays %v%% and aborts %v%% of requests",
+						port, u.percentages.DelayPercent, u.percentages.AbortPercent)
+				}
+
+				u.done <- err
+			}
+		}
+	})
+
+	env.Handle(FaultControlPath(port), func(r *http.Request) (interface{}, error) {
+		if r.Method == http.MethodPost {
+			var p FaultPercentages
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return nil, err
+			}
+
+			if err := p.Validate(); err != nil {
+				return nil, err
+			}
+
+			u := faultUpdate{percentages: p, done: make(chan error, 1)}
+
+			select {
+			case updates <- u:
+			case <-r.Context().Done():
+				return nil, r.Context().Err()
+			}
+
+			if err := <-u.done; err != nil {
+				return nil, err
+			}
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		return current, nil
+	})
+}
@@ -0,0 +1,280 @@
+package hacks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+)
+
+// ParameterType is the type of a hack parameter value.
+type ParameterType string
+
+const (
+	// StringParameter is a string value.
+	StringParameter ParameterType = "string"
+	// IntParameter is an integer value.
+	IntParameter ParameterType = "int"
+	// FloatParameter is a floating point value.
+	FloatParameter ParameterType = "float"
+	// BoolParameter is a boolean value. A boolean parameter with
+	// no value is true.
+	BoolParameter ParameterType = "bool"
+	// IPParameter is an IPv4 or IPv6 address.
+	IPParameter ParameterType = "ip"
+	// AddressParameter is an "IP:PORT" address.
+	AddressParameter ParameterType = "address"
+	// PortParameter is a port number in the range 1-65535.
+	PortParameter ParameterType = "port"
+	// DurationParameter is a Go duration, e.g. "1m30s".
+	DurationParameter ParameterType = "duration"
+	// ListParameter is a list of values. A single scalar value is
+	// a list of one element.
+	ListParameter ParameterType = "list"
+	// MapParameter is a map of named values.
+	MapParameter ParameterType = "map"
+)
+
+// Known returns whether t is one of the parameter types.
+func (t ParameterType) Known() bool {
+	switch t {
+	case StringParameter, IntParameter, FloatParameter, BoolParameter,
+		IPParameter, AddressParameter, PortParameter, DurationParameter,
+		ListParameter, MapParameter:
+		return true
+	default:
+		return false
+	}
+}
+
+// Check returns an error if the parameter is not a valid value of
+// this type.
+func (t ParameterType) Check(p Parameter) error {
+	var err error
+
+	switch t {
+	case StringParameter:
+		_, err = p.AsString()
+	case IntParameter:
+		_, err = p.AsInt64()
+	case FloatParameter:
+		_, err = p.AsFloat64()
+	case BoolParameter:
+		_, err = p.AsBool()
+	case IPParameter:
+		_, err = p.IP()
+	case AddressParameter:
+		_, err = p.TCPAddr()
+	case PortParameter:
+		if port, err := strconv.ParseUint(p.Value, 10, 16); err != nil || port == 0 || !p.IsScalar() {
+			return fmt.Errorf("invalid port value %s (must be 1-65535)", p)
+		}
+	case DurationParameter:
+		_, err = p.AsDuration()
+	case ListParameter:
+		_, err = p.AsList()
+	case MapParameter:
+		_, err = p.AsMap()
+	default:
+		return fmt.Errorf("unknown parameter type %q", t)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s value %s", t, p)
+	}
+
+	return nil
+}
+
+// ParameterSchema describes a hack parameter.
+type ParameterSchema struct {
+	Name     string
+	Type     ParameterType
+	Default  string
+	Required bool
+	Help     string
+}
+
+// Env is the environment that hacks are built in.
+type Env struct {
+	// RunDir is the run directory of envoy-bootstrap. Hacks can
+	// write files that clients need, e.g. certificates, here.
+	RunDir string
+
+	// Node is the Envoy node that the hack is built for. Hacks can
+	// use it to set options that depend on the node's platform.
+	Node *bootstrap.Node
+
+	// Go starts a task that runs in the background after the
+	// hack's initial resources are published. Go is nil when the
+	// hack is built outside of a running Envoy, e.g. to print its
+	// resources.
+	Go func(Task)
+
+	// Handle registers a control endpoint for the hack at the given
+	// path. The function returns a value that is encoded as the JSON
+	// response. Handle is nil when Go is nil.
+	Handle func(path string, f func(*http.Request) (interface{}, error))
+}
+
+// Task is a background task for a hack. It publishes new versions of
+// the hack's resources with update, replacing the resources returned
+// by Build, and it returns once the context is done.
+type Task func(ctx context.Context, update func(xds.Snapshot) error)
+
+// Hack is a parameterized generator of Envoy resources.
+type Hack interface {
+	// Name is the name that the hack is specified by.
+	Name() string
+	// Description is a short description of the hack.
+	Description() string
+	// Parameters is the schema of the hack parameters.
+	Parameters() []ParameterSchema
+	// Build generates the Envoy resources for the spec.
+	Build(Env, Spec) (xds.Snapshot, error)
+}
+
+type hack struct {
+	name        string
+	description string
+	parameters  []ParameterSchema
+	build       func(Env, Spec) (xds.Snapshot, error)
+}
+
+var _ Hack = &hack{}
+
+// New returns a Hack with the given schema and build function.
+// Before the build function is called, the spec is validated against
+// the schema and any missing parameters are set to their default.
+func New(name string, description string, params []ParameterSchema, build func(Env, Spec) (xds.Snapshot, error)) Hack {
+	return &hack{
+		name:        name,
+		description: description,
+		parameters:  params,
+		build:       build,
+	}
+}
+
+func (h *hack) Name() string                  { return h.name }
+func (h *hack) Description() string           { return h.description }
+func (h *hack) Parameters() []ParameterSchema { return h.parameters }
+
+func (h *hack) Build(env Env, spec Spec) (xds.Snapshot, error) {
+	if err := Validate(h.parameters, spec); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	params := make(map[string]Parameter, len(spec.Parameters))
+	for k, v := range spec.Parameters {
+		params[k] = v
+	}
+
+	for _, p := range h.parameters {
+		if _, ok := params[p.Name]; !ok && p.Default != "" {
+			params[p.Name] = NewParameter(p.Default)
+		}
+	}
+
+	return h.build(env, Spec{Hack: spec.Hack, Parameters: params})
+}
+
+// ValidationError lists all the problems found when validating a
+// spec against a parameter schema.
+type ValidationError struct {
+	Hack   string
+	Errors []error
+}
+
+func (v *ValidationError) Error() string {
+	var msgs []string
+	for _, err := range v.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("invalid %q parameters: %s", v.Hack, strings.Join(msgs, "; "))
+}
+
+// Validate checks the spec parameters against a parameter schema,
+// usually the schema of the hack. It reports unknown parameters,
+// values of the wrong type and missing required parameters in a
+// single ValidationError.
+func Validate(params []ParameterSchema, spec Spec) error {
+	var errs []error
+
+	schema := map[string]ParameterSchema{}
+	for _, p := range params {
+		schema[p.Name] = p
+	}
+
+	var names []string
+	for name := range spec.Parameters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, ok := schema[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown parameter %q", name))
+			continue
+		}
+
+		if err := p.Type.Check(spec.Parameters[name]); err != nil {
+			errs = append(errs, fmt.Errorf("parameter %q: %w", name, err))
+		}
+	}
+
+	for _, p := range params {
+		if _, ok := spec.Parameters[p.Name]; p.Required && !ok {
+			errs = append(errs, fmt.Errorf("missing required parameter %q", p.Name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Hack: spec.Hack, Errors: errs}
+	}
+
+	return nil
+}
+
+var registry = map[string]Hack{}
+
+// Register adds a hack to the registry. It panics if a hack with
+// the same name is already registered.
+func Register(h Hack) {
+	if _, ok := registry[h.Name()]; ok {
+		panic(fmt.Sprintf("hack %q is already registered", h.Name()))
+	}
+
+	registry[h.Name()] = h
+}
+
+// Lookup returns the registered hack with the given name. If no hack
+// is registered with that name, it looks for a hack plugin.
+func Lookup(name string) (Hack, bool) {
+	if h, ok := registry[name]; ok {
+		return h, true
+	}
+
+	return LookupPlugin(name)
+}
+
+// Registered returns all the registered hacks, sorted by name.
+func Registered() []Hack {
+	var all []Hack
+	for _, h := range registry {
+		all = append(all, h)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+
+	return all
+}
@@ -0,0 +1,336 @@
+package hacks
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/admin"
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/endpoints"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_network_tcp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/golang/protobuf/ptypes"
+)
+
+func init() {
+	Register(New("health",
+		"Listener for a cluster with active health checking and outlier detection",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "upstreams", Type: ListParameter, Required: true, Help: "Upstream addresses of the cluster hosts"},
+			{Name: "protocol", Type: StringParameter, Default: "http",
+				Help: `Health check protocol ("http", "tcp" or "grpc")`},
+			{Name: "path", Type: StringParameter, Default: "/healthz", Help: "HTTP health check path"},
+			{Name: "service", Type: StringParameter, Help: "gRPC health check service name"},
+			{Name: "interval", Type: DurationParameter, Default: "5s", Help: "Interval between health checks"},
+			{Name: "timeout", Type: DurationParameter, Default: "1s", Help: "Health check timeout"},
+			{Name: "unhealthy_threshold", Type: IntParameter, Default: "2",
+				Help: "Number of failed health checks before a host is unhealthy"},
+			{Name: "healthy_threshold", Type: IntParameter, Default: "1",
+				Help: "Number of passed health checks before a host is healthy"},
+			{Name: "outlier_detection", Type: BoolParameter, Default: "true", Help: "Eject hosts that fail requests"},
+			{Name: "consecutive_5xx", Type: IntParameter, Default: "5",
+				Help: "Number of consecutive 5xx responses before a host is ejected"},
+			{Name: "outlier_interval", Type: DurationParameter, Default: "10s", Help: "Interval between ejection sweeps"},
+			{Name: "base_ejection_time", Type: DurationParameter, Default: "30s", Help: "Base time that a host is ejected for"},
+			{Name: "max_ejection_percent", Type: IntParameter, Default: "100",
+				Help: "Maximum percentage of hosts that can be ejected"},
+			{Name: "watch_interval", Type: DurationParameter, Default: "1s",
+				Help: "Interval for printing host health transitions from the admin API (0 disables)"},
+		},
+		HackHealth,
+	))
+}
+
+// healthCheckPayload is what the TCP health check sends, and expects
+// to receive back, e.g. from the tcp backend, which echoes it.
+var healthCheckPayload = hex.EncodeToString([]byte("ping"))
+
+// newHealthCheck returns an active health check for the protocol.
+func newHealthCheck(spec Spec) (*envoy_config_core_v3.HealthCheck, error) {
+	interval, err := spec.Parameters["interval"].AsDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := spec.Parameters["timeout"].AsDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 || timeout <= 0 {
+		return nil, fmt.Errorf("health check interval and timeout must be positive")
+	}
+
+	unhealthy, err := spec.Parameters["unhealthy_threshold"].AsInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	healthy, err := spec.Parameters["healthy_threshold"].AsInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	if unhealthy < 1 || healthy < 1 {
+		return nil, fmt.Errorf("health check thresholds must be at least 1")
+	}
+
+	check := &envoy_config_core_v3.HealthCheck{
+		Timeout:            ptypes.DurationProto(timeout),
+		Interval:           ptypes.DurationProto(interval),
+		UnhealthyThreshold: bootstrap.UInt32(uint32(unhealthy)),
+		HealthyThreshold:   bootstrap.UInt32(uint32(healthy)),
+	}
+
+	switch protocol := spec.Parameters["protocol"].Value; protocol {
+	case "http":
+		healthPath := spec.Parameters["path"].Value
+		if !strings.HasPrefix(healthPath, "/") {
+			return nil, fmt.Errorf("invalid health check path %q", healthPath)
+		}
+
+		check.HealthChecker = &envoy_config_core_v3.HealthCheck_HttpHealthCheck_{
+			HttpHealthCheck: &envoy_config_core_v3.HealthCheck_HttpHealthCheck{
+				Path: healthPath,
+			},
+		}
+	case "tcp":
+		check.HealthChecker = &envoy_config_core_v3.HealthCheck_TcpHealthCheck_{
+			TcpHealthCheck: &envoy_config_core_v3.HealthCheck_TcpHealthCheck{
+				Send: &envoy_config_core_v3.HealthCheck_Payload{
+					Payload: &envoy_config_core_v3.HealthCheck_Payload_Text{Text: healthCheckPayload},
+				},
+				Receive: []*envoy_config_core_v3.HealthCheck_Payload{{
+					Payload: &envoy_config_core_v3.HealthCheck_Payload_Text{Text: healthCheckPayload},
+				}},
+			},
+		}
+	case "grpc":
+		check.HealthChecker = &envoy_config_core_v3.HealthCheck_GrpcHealthCheck_{
+			GrpcHealthCheck: &envoy_config_core_v3.HealthCheck_GrpcHealthCheck{
+				ServiceName: spec.Parameters["service"].Value,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("invalid protocol %q (must be http, tcp or grpc)", protocol)
+	}
+
+	return check, nil
+}
+
+// newOutlierDetection returns the outlier detection settings, or nil
+// if outlier detection is disabled.
+func newOutlierDetection(spec Spec) (*envoy_config_cluster_v3.OutlierDetection, error) {
+	enabled, err := spec.Parameters["outlier_detection"].AsBool()
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	consecutive, err := spec.Parameters["consecutive_5xx"].AsInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	if consecutive < 1 {
+		return nil, fmt.Errorf("invalid consecutive_5xx %d (must be at least 1)", consecutive)
+	}
+
+	interval, err := spec.Parameters["outlier_interval"].AsDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	ejection, err := spec.Parameters["base_ejection_time"].AsDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 || ejection <= 0 {
+		return nil, fmt.Errorf("outlier_interval and base_ejection_time must be positive")
+	}
+
+	maxPercent, err := spec.Parameters["max_ejection_percent"].AsInt64()
+	if err != nil {
+		return nil, err
+	}
+
+	if maxPercent < 0 || maxPercent > 100 {
+		return nil, fmt.Errorf("invalid max_ejection_percent %d (must be 0-100)", maxPercent)
+	}
+
+	return &envoy_config_cluster_v3.OutlierDetection{
+		Consecutive_5Xx:    bootstrap.UInt32(uint32(consecutive)),
+		Interval:           ptypes.DurationProto(interval),
+		BaseEjectionTime:   ptypes.DurationProto(ejection),
+		MaxEjectionPercent: bootstrap.UInt32(uint32(maxPercent)),
+	}, nil
+}
+
+// HackHealth builds a listener that forwards to an EDS cluster of the
+// upstream addresses, which Envoy health checks over HTTP, TCP or
+// gRPC. Hosts that fail requests are also ejected by outlier
+// detection. HTTP and gRPC are proxied by an HTTP listener, and TCP
+// by a TCP proxy listener.
+//
+// While Envoy runs, host health transitions are read from the admin
+// API and logged. The built-in backends can be made unhealthy with
+// "ctl backends NAME unhealthy" to watch hosts being ejected.
+func HackHealth(env Env, spec Spec) (xds.Snapshot, error) {
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	watchInterval, err := spec.Parameters["watch_interval"].AsDuration()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	upstreams, err := spec.Parameters["upstreams"].AsList()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	var addrs []string
+	for _, u := range upstreams {
+		addrs = append(addrs, u.Value)
+	}
+
+	eps, err := newUpstreamEndpoints(addrs...)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	check, err := newHealthCheck(spec)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	outliers, err := newOutlierDetection(spec)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	listenerName := fmt.Sprintf("hack/health/listener/%d", port)
+	routeName := fmt.Sprintf("hack/health/route/%d", port)
+	clusterName := fmt.Sprintf("hack/health/cluster/%d", port)
+	statPrefix := strings.Replace(listenerName, "/", "-", -1)
+
+	assignment, err := endpoints.NewLoadAssignment(clusterName, eps)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	cluster := bootstrap.NewEdsCluster(clusterName)
+	cluster.HealthChecks = []*envoy_config_core_v3.HealthCheck{check}
+	cluster.OutlierDetection = outliers
+
+	snap := xds.Snapshot{}
+
+	switch spec.Parameters["protocol"].Value {
+	case "tcp":
+		snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), NewTCPListener(listenerName, addr, port,
+			bootstrap.NewFilter("envoy.filters.network.tcp_proxy",
+				bootstrap.ProtoV2(&envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy{
+					StatPrefix: statPrefix,
+					ClusterSpecifier: &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_Cluster{
+						Cluster: clusterName,
+					},
+				}))))
+	case "grpc":
+		cluster.Http2ProtocolOptions = &envoy_config_core_v3.Http2ProtocolOptions{}
+		fallthrough
+	default:
+		snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), NewTCPListener(listenerName, addr, port,
+			NewHTTPConnectionManager(statPrefix, routeName)))
+		snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(),
+			NewRouteConfiguration(routeName, bootstrap.NewClusterRoute("/", clusterName)))
+	}
+
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), cluster)
+	snap.Resources[xds.EndpointType] = xds.NewResources(NewVersion(), assignment)
+
+	if env.Go != nil && watchInterval > 0 {
+		client := admin.NewClient(path.Join(env.RunDir, admin.SocketName))
+		env.Go(func(ctx context.Context, _ func(xds.Snapshot) error) {
+			watchHostHealth(ctx, client, clusterName, watchInterval)
+		})
+	}
+
+	return snap, nil
+}
+
+// watchHostHealth logs the health of each host in the cluster when it
+// is first seen, and each time it changes.
+func watchHostHealth(ctx context.Context, client *admin.Client, cluster string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	hosts := map[string]admin.HostHealth{}
+	lastErr := ""
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		health, err := client.HostHealth(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Only log errors once, since the admin API isn't
+		// available until Envoy is running.
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("health: %s: %s", cluster, err)
+				lastErr = err.Error()
+			}
+
+			continue
+		}
+
+		lastErr = ""
+
+		for _, h := range health {
+			if h.Cluster != cluster {
+				continue
+			}
+
+			prev, ok := hosts[h.Address]
+			hosts[h.Address] = h
+
+			if ok && prev.Healthy == h.Healthy && strings.Join(prev.Reasons, ",") == strings.Join(h.Reasons, ",") {
+				continue
+			}
+
+			state := "healthy"
+			if !h.Healthy {
+				state = "unhealthy"
+			}
+
+			if len(h.Reasons) > 0 {
+				state = fmt.Sprintf("%s (%s)", state, strings.Join(h.Reasons, ", "))
+			}
+
+			log.Printf("health: %s: host %s is %s", cluster, h.Address, state)
+		}
+	}
+}
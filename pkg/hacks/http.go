@@ -0,0 +1,132 @@
+package hacks
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// NewHTTPConnectionManager returns an HTTP connection manager filter
+// that obtains the named route configuration over ADS. The router
+// filter is appended to the given HTTP filters.
+func NewHTTPConnectionManager(statPrefix string, routeName string, filters ...*bootstrap.HTTPFilter) *bootstrap.Filter {
+	type RouteSpecifier = envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager_Rds
+	type RDS = envoy_extensions_filters_network_http_connection_manager_v3.Rds
+
+	filters = append(filters, &bootstrap.HTTPFilter{
+		Name: "envoy.filters.http.router",
+	})
+
+	return bootstrap.NewFilter(
+		"envoy.filters.network.http_connection_manager",
+		bootstrap.ProtoV2(&HTTPConnectionManager{
+			RouteSpecifier: &RouteSpecifier{
+				Rds: &RDS{
+					RouteConfigName: routeName,
+					ConfigSource: &bootstrap.ConfigSource{
+						ConfigSourceSpecifier: bootstrap.NewAdsConfigSource(),
+						ResourceApiVersion:    envoy_config_core_v3.ApiVersion_V3,
+					},
+				},
+			},
+			StatPrefix:  statPrefix,
+			HttpFilters: filters,
+		}),
+	)
+}
+
+// NewTCPListener returns a listener on the given address with a
+// single filter chain containing the given network filters.
+func NewTCPListener(name string, addr net.IP, port int64, filters ...*bootstrap.Filter) *bootstrap.Listener {
+	return &bootstrap.Listener{
+		Name: name,
+		Address: bootstrap.NewSocketAddress(
+			&bootstrap.SocketAddress{
+				Protocol:      bootstrap.TCP,
+				Address:       addr.String(),
+				PortSpecifier: bootstrap.NewPortValue(uint32(port)),
+			}),
+		FilterChains: []*bootstrap.FilterChain{
+			&bootstrap.FilterChain{
+				Filters: filters,
+			},
+		},
+		ListenerFiltersTimeout: ptypes.DurationProto(time.Second * 15), // Default.
+		TrafficDirection:       bootstrap.INBOUND,
+	}
+}
+
+// NewRouteConfiguration returns a route configuration with a single
+// virtual host that matches all domains.
+func NewRouteConfiguration(name string, routes ...*bootstrap.Route) *bootstrap.RouteConfiguration {
+	return &bootstrap.RouteConfiguration{
+		Name: name,
+		VirtualHosts: []*bootstrap.VirtualHost{
+			&bootstrap.VirtualHost{
+				Name:    name,
+				Domains: []string{"*"},
+				Routes:  routes,
+			},
+		},
+	}
+}
+
+// NewUpstreamRoute returns a route that forwards all requests to the
+// "upstream" address parameter, along with the static cluster for
+// that address. If there is no upstream address, the route responds
+// directly with a 200 status.
+func NewUpstreamRoute(spec Spec, clusterName string) (*bootstrap.Route, []protov1.Message, error) {
+	if spec.Parameters["upstream"].IsZero() {
+		return bootstrap.NewDirectResponseRoute("/", 200, "OK\n"), nil, nil
+	}
+
+	upstream, err := spec.Parameters["upstream"].TCPAddr()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bootstrap.NewClusterRoute("/", clusterName),
+		[]protov1.Message{
+			bootstrap.NewStaticCluster(clusterName, bootstrap.NewTCPAddress(upstream)),
+		}, nil
+}
+
+// NewRouteAction returns a function that makes routes for a path
+// prefix. Routes forward requests to the "upstream" address parameter,
+// with the returned static cluster for that address, or else to the
+// "cluster" parameter. If neither is given, routes respond directly
+// with a 200 status. It is an error to give both.
+func NewRouteAction(spec Spec, clusterName string) (func(prefix string) *bootstrap.Route, []protov1.Message, error) {
+	switch {
+	case !spec.Parameters["upstream"].IsZero() && !spec.Parameters["cluster"].IsZero():
+		return nil, nil, fmt.Errorf("the cluster and upstream parameters cannot be used together")
+	case !spec.Parameters["upstream"].IsZero():
+		upstream, err := spec.Parameters["upstream"].TCPAddr()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return func(prefix string) *bootstrap.Route {
+				return bootstrap.NewClusterRoute(prefix, clusterName)
+			},
+			[]protov1.Message{
+				bootstrap.NewStaticCluster(clusterName, bootstrap.NewTCPAddress(upstream)),
+			}, nil
+	case !spec.Parameters["cluster"].IsZero():
+		cluster := spec.Parameters["cluster"].Value
+		return func(prefix string) *bootstrap.Route {
+			return bootstrap.NewClusterRoute(prefix, cluster)
+		}, nil, nil
+	default:
+		return func(prefix string) *bootstrap.Route {
+			return bootstrap.NewDirectResponseRoute(prefix, 200, "OK\n")
+		}, nil, nil
+	}
+}
@@ -0,0 +1,269 @@
+package hacks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_http_local_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	envoy_extensions_filters_network_local_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/local_ratelimit/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+)
+
+func init() {
+	Register(New("localratelimit",
+		"HTTP listener with token bucket rate limiting of requests, connections or both",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "level", Type: StringParameter, Default: "http",
+				Help: `What to limit: requests ("http"), connections ("connection") or both ("both")`},
+			{Name: "tokens", Type: IntParameter, Default: "10", Help: "Maximum number of tokens in the bucket"},
+			{Name: "tokens_per_fill", Type: IntParameter, Help: "Number of tokens added at each fill (defaults to tokens)"},
+			{Name: "fill_interval", Type: DurationParameter, Default: "1s", Help: "Interval between bucket fills"},
+			{Name: "status", Type: IntParameter, Default: "429", Help: "HTTP status of rate limited requests"},
+			{Name: "response_headers", Type: MapParameter,
+				Help: "Headers to add to rate limited responses, e.g. {x-rate-limited=true}"},
+			{Name: "routes", Type: ListParameter,
+				Help: "Per-route token buckets, e.g. [{prefix=/slow,tokens=1,fill_interval=10s}]"},
+			{Name: "cluster", Type: StringParameter, Help: "Cluster to route requests to, e.g. backend/http"},
+			{Name: "upstream", Type: AddressParameter, Help: "Upstream address to route requests to"},
+		},
+		HackLocalRateLimit,
+	))
+}
+
+// newTokenBucket returns the token bucket described by the "tokens",
+// "tokens_per_fill" and "fill_interval" parameters. Missing
+// parameters are taken from the defaults, except that a bucket is
+// refilled with its "tokens" unless "tokens_per_fill" is given.
+func newTokenBucket(params map[string]Parameter, defaults *envoy_type_v3.TokenBucket) (*envoy_type_v3.TokenBucket, error) {
+	bucket := &envoy_type_v3.TokenBucket{}
+	if defaults != nil {
+		bucket.MaxTokens = defaults.MaxTokens
+		bucket.TokensPerFill = defaults.TokensPerFill
+		bucket.FillInterval = defaults.FillInterval
+	}
+
+	if !params["tokens"].IsZero() {
+		tokens, err := params["tokens"].AsInt64()
+		if err != nil {
+			return nil, err
+		}
+
+		if tokens < 1 {
+			return nil, fmt.Errorf("invalid tokens %d (must be at least 1)", tokens)
+		}
+
+		bucket.MaxTokens = uint32(tokens)
+		bucket.TokensPerFill = bootstrap.UInt32(uint32(tokens))
+	}
+
+	if !params["tokens_per_fill"].IsZero() {
+		tokens, err := params["tokens_per_fill"].AsInt64()
+		if err != nil {
+			return nil, err
+		}
+
+		if tokens < 1 {
+			return nil, fmt.Errorf("invalid tokens_per_fill %d (must be at least 1)", tokens)
+		}
+
+		bucket.TokensPerFill = bootstrap.UInt32(uint32(tokens))
+	}
+
+	if !params["fill_interval"].IsZero() {
+		interval, err := params["fill_interval"].AsDuration()
+		if err != nil {
+			return nil, err
+		}
+
+		// Envoy rejects fill intervals shorter than 50ms.
+		if interval < 50*time.Millisecond {
+			return nil, fmt.Errorf("invalid fill_interval %s (must be at least 50ms)", interval)
+		}
+
+		bucket.FillInterval = ptypes.DurationProto(interval)
+	}
+
+	return bucket, nil
+}
+
+// HackLocalRateLimit builds an HTTP listener that limits requests with
+// the HTTP local rate limit filter, limits connections with the
+// network local rate limit filter, or both. The "routes" parameter
+// gives routes their own token bucket. Requests are routed to the
+// named cluster or upstream address, or get a direct response if
+// neither is given.
+func HackLocalRateLimit(_ Env, spec Spec) (xds.Snapshot, error) {
+	type HTTPLocalRateLimit = envoy_extensions_filters_http_local_ratelimit_v3.LocalRateLimit
+	type NetworkLocalRateLimit = envoy_extensions_filters_network_local_ratelimit_v3.LocalRateLimit
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	status, err := spec.Parameters["status"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if status < 200 || status > 599 {
+		return xds.Snapshot{}, fmt.Errorf("invalid status %d (must be 200-599)", status)
+	}
+
+	var limitRequests, limitConnections bool
+
+	switch level := spec.Parameters["level"].Value; level {
+	case "http":
+		limitRequests = true
+	case "connection":
+		limitConnections = true
+	case "both":
+		limitRequests, limitConnections = true, true
+	default:
+		return xds.Snapshot{}, fmt.Errorf("invalid level %q (must be http, connection or both)", level)
+	}
+
+	bucket, err := newTokenBucket(spec.Parameters, nil)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	headers, err := spec.Parameters["response_headers"].AsMap()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	var headerNames []string
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+
+	sort.Strings(headerNames)
+
+	var responseHeaders []*envoy_config_core_v3.HeaderValueOption
+	for _, name := range headerNames {
+		responseHeaders = append(responseHeaders, &envoy_config_core_v3.HeaderValueOption{
+			Header: &envoy_config_core_v3.HeaderValue{Key: name, Value: headers[name].Value},
+			Append: bootstrap.False(),
+		})
+	}
+
+	listenerName := fmt.Sprintf("hack/localratelimit/listener/%d", port)
+	routeName := fmt.Sprintf("hack/localratelimit/route/%d", port)
+	upstreamName := fmt.Sprintf("hack/localratelimit/cluster/%d", port)
+	statPrefix := strings.Replace(listenerName, "/", "-", -1)
+
+	// newHTTPLimit returns an HTTP local rate limit config that is
+	// enabled and enforced for all requests.
+	newHTTPLimit := func(b *envoy_type_v3.TokenBucket) *HTTPLocalRateLimit {
+		enabled := &envoy_config_core_v3.RuntimeFractionalPercent{
+			DefaultValue: bootstrap.NewPercent(100),
+		}
+
+		return &HTTPLocalRateLimit{
+			StatPrefix:           statPrefix,
+			Status:               &envoy_type_v3.HttpStatus{Code: envoy_type_v3.StatusCode(status)},
+			TokenBucket:          b,
+			FilterEnabled:        enabled,
+			FilterEnforced:       enabled,
+			ResponseHeadersToAdd: responseHeaders,
+		}
+	}
+
+	action, clusters, err := NewRouteAction(spec, upstreamName)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	overrides, err := spec.Parameters["routes"].AsList()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if len(overrides) > 0 && !limitRequests {
+		return xds.Snapshot{}, fmt.Errorf("per-route token buckets need the http level")
+	}
+
+	var routes []*bootstrap.Route
+
+	for i, o := range overrides {
+		fields, err := o.AsMap()
+		if err != nil {
+			return xds.Snapshot{}, fmt.Errorf("route %d: %w", i, err)
+		}
+
+		prefix := fields["prefix"].Value
+		if !strings.HasPrefix(prefix, "/") {
+			return xds.Snapshot{}, fmt.Errorf("route %d: invalid prefix %q", i, prefix)
+		}
+
+		for name := range fields {
+			switch name {
+			case "prefix", "tokens", "tokens_per_fill", "fill_interval":
+			default:
+				return xds.Snapshot{}, fmt.Errorf("route %d: unknown field %q", i, name)
+			}
+		}
+
+		routeBucket, err := newTokenBucket(fields, bucket)
+		if err != nil {
+			return xds.Snapshot{}, fmt.Errorf("route %d: %w", i, err)
+		}
+
+		config, err := bootstrap.MarshalAny(bootstrap.ProtoV2(newHTTPLimit(routeBucket)))
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		r := action(prefix)
+		r.TypedPerFilterConfig = map[string]*any.Any{
+			"envoy.filters.http.local_ratelimit": config,
+		}
+
+		routes = append(routes, r)
+	}
+
+	var httpFilters []*bootstrap.HTTPFilter
+	if limitRequests {
+		httpFilters = append(httpFilters, bootstrap.NewHTTPFilter(
+			"envoy.filters.http.local_ratelimit",
+			bootstrap.ProtoV2(newHTTPLimit(bucket)),
+		))
+	}
+
+	var filters []*bootstrap.Filter
+	if limitConnections {
+		filters = append(filters, bootstrap.NewFilter(
+			"envoy.filters.network.local_ratelimit",
+			bootstrap.ProtoV2(&NetworkLocalRateLimit{
+				StatPrefix:  statPrefix,
+				TokenBucket: bucket,
+			}),
+		))
+	}
+
+	filters = append(filters, NewHTTPConnectionManager(statPrefix, routeName, httpFilters...))
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), NewTCPListener(listenerName, addr, port, filters...))
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(),
+		NewRouteConfiguration(routeName, append(routes, action("/"))...))
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+
+	return snap, nil
+}
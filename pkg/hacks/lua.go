@@ -3,13 +3,10 @@ package hacks
 import (
 	"fmt"
 	"strings"
-	"time"
 
-	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_extensions_filters_http_lua_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/lua/v3"
-	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
 	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
-	"github.com/jpeach/envoy-bootstrap/pkg/must"
 	"github.com/jpeach/envoy-bootstrap/pkg/xds"
 
 	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
@@ -18,118 +15,172 @@ import (
 type HTTPConnectionManager = envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager
 type HTTPFilter = envoy_extensions_filters_network_http_connection_manager_v3.HttpFilter
 
-func FilterMisdirectedRequests(fqdn string) *bootstrap.HTTPFilter {
-	type Lua = envoy_extensions_filters_http_lua_v3.Lua
-
-	code := `
-function envoy_on_request(request_handle)
-        local headers = request_handle:headers()
-        local host = headers:get(":authority")
-        local target = "%s"
-
-        if host ~= target then
-                s, e = string.find(host, ":", 1, true)
-                if s ~= nil then
-                        host = string.sub(host, 1, s - 1)
-                end
-
-                if host ~= target then
-                        request_handle:respond(
-                                {[":status"] = "421"},
-                                string.format("misdirected request to %%q", headers:get(":authority"))
-                        )
-                end
-
-        end
+// DefaultLuaScript is the Lua filter script that is used if the lua
+// hack isn't given a script. It marks each response so that it is
+// easy to see that the filter ran.
+const DefaultLuaScript = `
+function envoy_on_response(response_handle)
+        response_handle:headers():add("x-envoy-bootstrap-lua", "default")
 end
-        `
+`
+
+func init() {
+	Register(New("lua",
+		"HTTP listener with a Lua filter and a lua-N.example.com virtual host per count",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "count", Type: IntParameter, Default: "1", Help: "Number of lua-N.example.com virtual hosts"},
+			{Name: "script", Type: StringParameter, Help: "Lua filter script, usually given as @path.lua"},
+			{Name: "routes", Type: ListParameter,
+				Help: "Per-route script overrides, e.g. [{prefix=/foo,script=@foo.lua},{prefix=/bar,disabled}]"},
+			{Name: "cluster", Type: StringParameter, Help: "Cluster to route requests to, e.g. backend/http"},
+			{Name: "upstream", Type: AddressParameter, Help: "Upstream address to route requests to"},
+		},
+		HackLuaFilter,
+	))
+}
 
-	return bootstrap.NewHTTPFilter(
-		"envoy.filters.http.lua",
-		bootstrap.ProtoV2(&Lua{InlineCode: fmt.Sprintf(code, fqdn)}),
-	)
+// newLuaRoutes returns a route with a LuaPerRoute override for each
+// of the "routes" parameters. Each route is a map with a "prefix",
+// and either a "script" or a "disabled" flag.
+func newLuaRoutes(spec Spec, action func(prefix string) *bootstrap.Route) ([]*bootstrap.Route, error) {
+	type LuaPerRoute = envoy_extensions_filters_http_lua_v3.LuaPerRoute
+
+	overrides, err := spec.Parameters["routes"].AsList()
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []*bootstrap.Route
+
+	for i, o := range overrides {
+		fields, err := o.AsMap()
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+
+		prefix := fields["prefix"].Value
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("route %d: invalid prefix %q", i, prefix)
+		}
+
+		perRoute := &LuaPerRoute{}
+
+		switch {
+		case !fields["script"].IsZero():
+			perRoute.Override = &envoy_extensions_filters_http_lua_v3.LuaPerRoute_SourceCode{
+				SourceCode: bootstrap.NewInlineString(fields["script"].Value),
+			}
+		case !fields["disabled"].IsZero():
+			disabled, err := fields["disabled"].AsBool()
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %w", i, err)
+			}
+
+			// Envoy rejects a LuaPerRoute that doesn't disable
+			// the filter, so the flag can only be true.
+			if !disabled {
+				return nil, fmt.Errorf("route %d: disabled must be true", i)
+			}
+
+			perRoute.Override = &envoy_extensions_filters_http_lua_v3.LuaPerRoute_Disabled{
+				Disabled: disabled,
+			}
+		default:
+			return nil, fmt.Errorf("route %d: missing script or disabled flag", i)
+		}
+
+		config, err := bootstrap.MarshalAny(bootstrap.ProtoV2(perRoute))
+		if err != nil {
+			return nil, err
+		}
+
+		r := action(prefix)
+		r.TypedPerFilterConfig = map[string]*any.Any{
+			"envoy.filters.http.lua": config,
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
 }
 
-// HackLuaFilter ...
-func HackLuaFilter(spec Spec) xds.Snapshot {
-	name := RandomStringN(10)
-	addr := must.IP(spec.Parameters["address"].IP())
-	port := must.Int64(spec.Parameters["port"].AsInt64())
-	cluster := must.String(spec.Parameters["cluster"].AsString())
-	count := must.Int64(spec.Parameters["count"].Or("1").AsInt64())
+// HackLuaFilter builds an HTTP listener with a Lua filter running
+// the given script. The listener has a virtual host for each of the
+// lua-N.example.com hostnames, which routes requests to the named
+// cluster, or to the upstream address. Requests get a direct
+// response if neither is given.
+func HackLuaFilter(_ Env, spec Spec) (xds.Snapshot, error) {
+	type Lua = envoy_extensions_filters_http_lua_v3.Lua
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
 
-	if cluster == "" {
-		cluster = fmt.Sprintf("lua/cluster/%d", port)
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
 	}
 
-	type RouteSpecifier = envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager_Rds
-	type RDS = envoy_extensions_filters_network_http_connection_manager_v3.Rds
+	count, err := spec.Parameters["count"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
 
-	var filterChains []*bootstrap.FilterChain
+	if count < 1 {
+		return xds.Snapshot{}, fmt.Errorf("invalid count %d (must be at least 1)", count)
+	}
+
+	listenerName := fmt.Sprintf("hack/lua/listener/%d", port)
+	routeName := fmt.Sprintf("hack/lua/route/%d", port)
+	upstreamName := fmt.Sprintf("hack/lua/cluster/%d", port)
+
+	action, clusters, err := NewRouteAction(spec, upstreamName)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	script := spec.Parameters["script"].Value
+	if script == "" {
+		script = DefaultLuaScript
+	}
+
+	filter := bootstrap.NewHTTPFilter(
+		"envoy.filters.http.lua",
+		bootstrap.ProtoV2(&Lua{InlineCode: script}),
+	)
+
+	listener := NewTCPListener(listenerName, addr, port,
+		NewHTTPConnectionManager(strings.Replace(listenerName, "/", "-", -1), routeName, filter))
+
+	routes := &bootstrap.RouteConfiguration{
+		Name: routeName,
+	}
 
 	for i := int64(0); i < count; i++ {
 		hostname := fmt.Sprintf("lua-%d.example.com", i)
-		c := &bootstrap.FilterChain{
-			FilterChainMatch: &bootstrap.FilterChainMatch{
-				ServerNames: []string{hostname},
-			},
-			Filters: []*bootstrap.Filter{
-				bootstrap.NewFilter(
-					"envoy.filters.network.http_connection_manager",
-					bootstrap.ProtoV2(&HTTPConnectionManager{
-						// RouteSpecifier field is required.
-						RouteSpecifier: &RouteSpecifier{
-							Rds: &RDS{
-								RouteConfigName: fmt.Sprintf("lua/route/%s", name),
-								ConfigSource: &bootstrap.ConfigSource{
-									ConfigSourceSpecifier: bootstrap.NewAdsConfigSource(),
-									ResourceApiVersion:    envoy_config_core_v3.ApiVersion_V3,
-								},
-							},
-						},
-						// StatPrefix field is required.
-						StatPrefix: strings.Replace(hostname, ".", "-", -1),
-						HttpFilters: []*bootstrap.HTTPFilter{
-							FilterMisdirectedRequests(hostname),
-							&bootstrap.HTTPFilter{
-								Name: "envoy.filters.http.gzip",
-							},
-							&bootstrap.HTTPFilter{
-								Name: "envoy.filters.http.grpc_web",
-							},
-							&bootstrap.HTTPFilter{
-								Name: "envoy.filters.http.router",
-							},
-						},
-					}),
-				),
-			},
-		}
 
-		filterChains = append(filterChains, c)
-	}
+		// Build the routes separately for each virtual host, since
+		// they must not share messages.
+		vhostRoutes, err := newLuaRoutes(spec, action)
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
 
-	listener := &bootstrap.Listener{
-		Name: "hack/lua/listener",
-		Address: bootstrap.NewSocketAddress(
-			&bootstrap.SocketAddress{
-				Protocol:      bootstrap.TCP,
-				Address:       addr.String(),
-				PortSpecifier: bootstrap.NewPortValue(uint32(port)),
-				Ipv4Compat:    false,
-			}),
-		FilterChains: filterChains,
-		ListenerFilters: []*bootstrap.ListenerFilter{
-			&bootstrap.ListenerFilter{
-				Name: "envoy.filters.listener.tls_inspector",
-			},
-		},
-		ListenerFiltersTimeout: ptypes.DurationProto(time.Second * 15), // Default.
-		AccessLog:              nil,
+		routes.VirtualHosts = append(routes.VirtualHosts, &bootstrap.VirtualHost{
+			Name:    hostname,
+			Domains: []string{hostname, hostname + ":*"},
+			Routes:  append(vhostRoutes, action("/")),
+		})
 	}
 
 	snap := xds.Snapshot{}
 	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), routes)
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
 
-	return snap
+	return snap, nil
 }
@@ -0,0 +1,213 @@
+package hacks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// PluginPrefix is the prefix of the name of a hack plugin executable.
+// The "foo" hack is implemented by "envoy-bootstrap-hack-foo".
+const PluginPrefix = "envoy-bootstrap-hack-"
+
+// PluginTimeout is how long a hack plugin can run before it is killed.
+const PluginTimeout = 30 * time.Second
+
+// PluginRequest is the JSON document that a hack plugin reads from
+// its standard input.
+type PluginRequest struct {
+	// Spec is the hack spec, e.g. {"hack":"foo","params":{"port":"80"}}.
+	Spec Spec `json:"spec"`
+	// Node is the Envoy node that the hack is built for, in the
+	// protobuf JSON encoding.
+	Node json.RawMessage `json:"node,omitempty"`
+	// RunDir is the run directory of envoy-bootstrap.
+	RunDir string `json:"runDir,omitempty"`
+}
+
+// PluginResponse is the JSON document that a hack plugin writes to
+// its standard output.
+type PluginResponse struct {
+	// Resources are the xDS resources that the hack built, in the
+	// protobuf JSON encoding of Any, e.g. {"@type":"type.googleapis.com/envoy.config.listener.v3.Listener", ...}.
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// pluginHack is a hack that is built by running a plugin executable.
+// Plugins don't publish a parameter schema, so they have to validate
+// the spec themselves.
+type pluginHack struct {
+	name string
+	path string
+}
+
+var _ Hack = &pluginHack{}
+
+func (p *pluginHack) Name() string                  { return p.name }
+func (p *pluginHack) Description() string           { return fmt.Sprintf("External hack plugin (%s)", p.path) }
+func (p *pluginHack) Parameters() []ParameterSchema { return nil }
+
+// Build runs the plugin with the request on its standard input, and
+// builds a snapshot from the resources that it writes to its
+// standard output. The plugin's standard error is passed through so
+// that it can log.
+func (p *pluginHack) Build(env Env, spec Spec) (xds.Snapshot, error) {
+	req := PluginRequest{
+		Spec:   spec,
+		RunDir: env.RunDir,
+	}
+
+	if env.Node != nil {
+		node, err := protojson.Marshal(bootstrap.ProtoV2(env.Node))
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		req.Node = node
+	}
+
+	input, err := json.Marshal(&req)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PluginTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return xds.Snapshot{}, fmt.Errorf("plugin %s failed: %w", p.path, err)
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(output.Bytes(), &resp); err != nil {
+		return xds.Snapshot{}, fmt.Errorf("plugin %s: invalid response: %w", p.path, err)
+	}
+
+	return newResourceSnapshot(resp.Resources)
+}
+
+// newResourceSnapshot decodes and validates xDS resources in the
+// protobuf JSON encoding of Any, and returns a snapshot of them.
+func newResourceSnapshot(resources []json.RawMessage) (xds.Snapshot, error) {
+	var items [len(xds.Snapshot{}.Resources)][]protov1.Message
+
+	names := map[string]bool{}
+
+	for i, r := range resources {
+		a := anypb.Any{}
+		if err := protojson.Unmarshal(r, &a); err != nil {
+			return xds.Snapshot{}, fmt.Errorf("resource %d: %w", i, err)
+		}
+
+		t := xds.ResourceType(a.GetTypeUrl())
+		if t == xds.UnknownType {
+			return xds.Snapshot{}, fmt.Errorf("resource %d: unsupported resource type %q", i, a.GetTypeUrl())
+		}
+
+		m, err := a.UnmarshalNew()
+		if err != nil {
+			return xds.Snapshot{}, fmt.Errorf("resource %d: %w", i, err)
+		}
+
+		if v, ok := m.(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return xds.Snapshot{}, fmt.Errorf("resource %d: %w", i, err)
+			}
+		}
+
+		resource := bootstrap.ProtoV1(m)
+
+		name := xds.ResourceName(resource)
+		if name == "" {
+			return xds.Snapshot{}, fmt.Errorf("resource %d: missing %s name", i, a.GetTypeUrl())
+		}
+
+		key := a.GetTypeUrl() + "/" + name
+		if names[key] {
+			return xds.Snapshot{}, fmt.Errorf("resource %d: duplicate %s %q", i, a.GetTypeUrl(), name)
+		}
+
+		names[key] = true
+		items[t] = append(items[t], resource)
+	}
+
+	snap := xds.Snapshot{}
+	for t := range items {
+		snap.Resources[t] = xds.NewResources(NewVersion(), items[t]...)
+	}
+
+	return snap, nil
+}
+
+// LookupPlugin returns the hack implemented by the plugin executable
+// for the given name, if there is one on the PATH.
+func LookupPlugin(name string) (Hack, bool) {
+	// Don't let the hack name pick an arbitrary path.
+	if name == "" || strings.ContainsRune(name, filepath.Separator) {
+		return nil, false
+	}
+
+	path, err := exec.LookPath(PluginPrefix + name)
+	if err != nil {
+		return nil, false
+	}
+
+	return &pluginHack{name: name, path: path}, true
+}
+
+// Plugins returns the hack plugins that are on the PATH, sorted by
+// name. If a plugin name is on the PATH more than once, the first one
+// is used. Plugins with the name of a registered hack are skipped,
+// since Lookup never finds them.
+func Plugins() []Hack {
+	var plugins []Hack
+
+	seen := map[string]bool{}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			name := strings.TrimPrefix(e.Name(), PluginPrefix)
+			if _, ok := registry[name]; ok || name == e.Name() || seen[name] {
+				continue
+			}
+
+			if h, ok := LookupPlugin(name); ok {
+				seen[name] = true
+				plugins = append(plugins, h)
+			}
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name() < plugins[j].Name()
+	})
+
+	return plugins
+}
@@ -0,0 +1,89 @@
+package hacks
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+)
+
+func TestNewResourceSnapshot(t *testing.T) {
+	const (
+		listener = `{"@type":"type.googleapis.com/envoy.config.listener.v3.Listener",` +
+			`"name":"l","address":{"socketAddress":{"address":"127.0.0.1","portValue":8080}}}`
+		cluster = `{"@type":"type.googleapis.com/envoy.config.cluster.v3.Cluster",` +
+			`"name":"c","connectTimeout":"1s"}`
+	)
+
+	for _, tc := range []struct {
+		name      string
+		resources []string
+		want      string
+		listeners int
+		clusters  int
+	}{
+		{
+			name:      "valid",
+			resources: []string{listener, cluster},
+			listeners: 1,
+			clusters:  1,
+		},
+		{
+			name:      "empty",
+			resources: nil,
+		},
+		{
+			name:      "invalid JSON",
+			resources: []string{`{"@type":`},
+			want:      "resource 0:",
+		},
+		{
+			name:      "unknown type",
+			resources: []string{`{"@type":"type.googleapis.com/google.protobuf.Duration","value":"1s"}`},
+			want:      `resource 0: unsupported resource type "type.googleapis.com/google.protobuf.Duration"`,
+		},
+		{
+			name:      "missing name",
+			resources: []string{`{"@type":"type.googleapis.com/envoy.config.route.v3.RouteConfiguration"}`},
+			want:      "resource 0: missing type.googleapis.com/envoy.config.route.v3.RouteConfiguration name",
+		},
+		{
+			name:      "duplicate",
+			resources: []string{cluster, listener, cluster},
+			want:      `resource 2: duplicate type.googleapis.com/envoy.config.cluster.v3.Cluster "c"`,
+		},
+		{
+			name: "invalid resource",
+			resources: []string{`{"@type":"type.googleapis.com/envoy.config.cluster.v3.Cluster",` +
+				`"name":"c","connectTimeout":"-1s"}`},
+			want: "resource 0: invalid Cluster.ConnectTimeout",
+		},
+	} {
+		var resources []json.RawMessage
+		for _, r := range tc.resources {
+			resources = append(resources, json.RawMessage(r))
+		}
+
+		snap, err := newResourceSnapshot(resources)
+		if tc.want != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("%s: got error %v, want %q", tc.name, err, tc.want)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		if n := len(snap.Resources[xds.ListenerType].Items); n != tc.listeners {
+			t.Fatalf("%s: got %d listeners, want %d", tc.name, n, tc.listeners)
+		}
+
+		if n := len(snap.Resources[xds.ClusterType].Items); n != tc.clusters {
+			t.Fatalf("%s: got %d clusters, want %d", tc.name, n, tc.clusters)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package hacks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/config/ratelimit/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_extensions_filters_http_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ratelimit/v3"
+)
+
+func init() {
+	Register(New("ratelimit",
+		"HTTP listener that checks requests with the rate limit service",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "domain", Type: StringParameter, Default: "envoy-bootstrap", Help: "Rate limit domain"},
+			{Name: "descriptor", Type: StringParameter, Default: "remote_address",
+				Help: `Rate limit descriptor ("remote_address", "path", "header:NAME" or "generic:VALUE")`},
+			{Name: "cluster", Type: StringParameter, Default: "xds", Help: "Rate limit service gRPC cluster"},
+			{Name: "upstream", Type: AddressParameter, Help: "Upstream address (requests get a direct response if not set)"},
+		},
+		HackRateLimit,
+	))
+}
+
+// newRateLimitAction returns the rate limit action for a descriptor
+// parameter, which is one of "remote_address", "path", "header:NAME"
+// or "generic:VALUE".
+func newRateLimitAction(descriptor string) (*envoy_config_route_v3.RateLimit_Action, error) {
+	type Action = envoy_config_route_v3.RateLimit_Action
+
+	parts := strings.SplitN(descriptor, ":", 2)
+
+	switch {
+	case descriptor == "remote_address":
+		return &Action{
+			ActionSpecifier: &envoy_config_route_v3.RateLimit_Action_RemoteAddress_{
+				RemoteAddress: &envoy_config_route_v3.RateLimit_Action_RemoteAddress{},
+			},
+		}, nil
+	case descriptor == "path":
+		return &Action{
+			ActionSpecifier: &envoy_config_route_v3.RateLimit_Action_RequestHeaders_{
+				RequestHeaders: &envoy_config_route_v3.RateLimit_Action_RequestHeaders{
+					HeaderName:    ":path",
+					DescriptorKey: "path",
+				},
+			},
+		}, nil
+	case len(parts) == 2 && parts[0] == "header":
+		return &Action{
+			ActionSpecifier: &envoy_config_route_v3.RateLimit_Action_RequestHeaders_{
+				RequestHeaders: &envoy_config_route_v3.RateLimit_Action_RequestHeaders{
+					HeaderName:    parts[1],
+					DescriptorKey: strings.ToLower(parts[1]),
+				},
+			},
+		}, nil
+	case len(parts) == 2 && parts[0] == "generic":
+		return &Action{
+			ActionSpecifier: &envoy_config_route_v3.RateLimit_Action_GenericKey_{
+				GenericKey: &envoy_config_route_v3.RateLimit_Action_GenericKey{
+					DescriptorValue: parts[1],
+				},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid rate limit descriptor %q", descriptor)
+	}
+}
+
+// HackRateLimit builds an HTTP listener that sends a rate limit
+// descriptor for each request to the rate limit service in the named
+// gRPC cluster. Requests are forwarded to the upstream address if
+// there is one, otherwise they get a direct response.
+func HackRateLimit(_ Env, spec Spec) (xds.Snapshot, error) {
+	domain := spec.Parameters["domain"].Value
+	descriptor := spec.Parameters["descriptor"].Value
+	cluster := spec.Parameters["cluster"].Value
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	action, err := newRateLimitAction(descriptor)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	listenerName := fmt.Sprintf("hack/ratelimit/listener/%d", port)
+	routeName := fmt.Sprintf("hack/ratelimit/route/%d", port)
+	upstreamName := fmt.Sprintf("hack/ratelimit/cluster/%d", port)
+
+	filter := bootstrap.NewHTTPFilter(
+		"envoy.filters.http.ratelimit",
+		bootstrap.ProtoV2(&envoy_extensions_filters_http_ratelimit_v3.RateLimit{
+			Domain: domain,
+			RateLimitService: &envoy_config_ratelimit_v3.RateLimitServiceConfig{
+				GrpcService:         bootstrap.NewGrpcService(cluster),
+				TransportApiVersion: envoy_config_core_v3.ApiVersion_V3,
+			},
+		}),
+	)
+
+	listener := NewTCPListener(listenerName, addr, port,
+		NewHTTPConnectionManager(strings.Replace(listenerName, "/", "-", -1), routeName, filter))
+
+	route, clusters, err := NewUpstreamRoute(spec, upstreamName)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	routes := NewRouteConfiguration(routeName, route)
+	routes.VirtualHosts[0].RateLimits = []*bootstrap.RateLimit{
+		&bootstrap.RateLimit{
+			Actions: []*envoy_config_route_v3.RateLimit_Action{action},
+		},
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), routes)
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+
+	return snap, nil
+}
@@ -0,0 +1,305 @@
+package hacks
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+)
+
+func init() {
+	Register(New("respond",
+		"HTTP listener that answers requests itself with a fixed response or a redirect",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "host", Type: ListParameter, Default: "*", Help: "Host names to answer for"},
+			{Name: "prefix", Type: StringParameter, Help: `Path prefix to match (default "/")`},
+			{Name: "path", Type: StringParameter, Help: "Exact path to match, instead of a prefix"},
+			{Name: "status", Type: IntParameter, Help: "Response status (default 200, or 301 for redirects)"},
+			{Name: "body", Type: StringParameter, Help: "Response body, usually given as @path"},
+			{Name: "content_type", Type: StringParameter, Help: "Content-Type of the response body"},
+			{Name: "redirect", Type: StringParameter,
+				Help: `Redirect to "https" on the same host and path, or to the scheme, host, port and path of a URL`},
+			{Name: "routes", Type: ListParameter,
+				Help: "Responses for other paths, matched first, e.g. [{path=/healthz,body=ok},{prefix=/old,redirect=/new}]"},
+		},
+		HackRespond,
+	))
+}
+
+// redirectCodes maps redirect statuses to their RedirectAction codes.
+var redirectCodes = map[int64]envoy_config_route_v3.RedirectAction_RedirectResponseCode{
+	301: envoy_config_route_v3.RedirectAction_MOVED_PERMANENTLY,
+	302: envoy_config_route_v3.RedirectAction_FOUND,
+	303: envoy_config_route_v3.RedirectAction_SEE_OTHER,
+	307: envoy_config_route_v3.RedirectAction_TEMPORARY_REDIRECT,
+	308: envoy_config_route_v3.RedirectAction_PERMANENT_REDIRECT,
+}
+
+// newRedirectAction returns a RedirectAction for the "redirect" value
+// of the respond hack. "https" redirects to HTTPS, anything else is a
+// URL whose non-empty parts replace the parts of the request URL.
+func newRedirectAction(redirect string, status int64) (*bootstrap.RedirectAction, error) {
+	code, ok := redirectCodes[status]
+	if !ok {
+		return nil, fmt.Errorf("invalid redirect status %d (must be 301, 302, 303, 307 or 308)", status)
+	}
+
+	action := &bootstrap.RedirectAction{
+		ResponseCode: code,
+	}
+
+	if redirect == "https" {
+		action.SchemeRewriteSpecifier = &envoy_config_route_v3.RedirectAction_HttpsRedirect{
+			HttpsRedirect: true,
+		}
+
+		return action, nil
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect: %w", err)
+	}
+
+	if u.Scheme != "" {
+		action.SchemeRewriteSpecifier = &envoy_config_route_v3.RedirectAction_SchemeRedirect{
+			SchemeRedirect: u.Scheme,
+		}
+	}
+
+	action.HostRedirect = u.Hostname()
+
+	if p := u.Port(); p != "" {
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect port %q", p)
+		}
+
+		action.PortRedirect = uint32(port)
+	}
+
+	if u.Path != "" {
+		action.PathRewriteSpecifier = &envoy_config_route_v3.RedirectAction_PathRedirect{
+			PathRedirect: u.Path,
+		}
+	}
+
+	if u.Scheme == "" && u.Host == "" && u.Path == "" {
+		return nil, fmt.Errorf("invalid redirect %q", redirect)
+	}
+
+	return action, nil
+}
+
+// newRespondRoute returns the route described by the given fields,
+// which are the same as the parameters of the respond hack.
+func newRespondRoute(fields map[string]Parameter) (*bootstrap.Route, error) {
+	for name := range fields {
+		switch name {
+		case "prefix", "path", "status", "body", "content_type", "redirect":
+		default:
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+	}
+
+	route := &bootstrap.Route{}
+
+	switch prefix, path := fields["prefix"].Value, fields["path"].Value; {
+	case prefix != "" && path != "":
+		return nil, fmt.Errorf("prefix and path cannot be used together")
+	case path != "":
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+
+		route.Match = bootstrap.NewPathMatch(path)
+	case prefix != "":
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid prefix %q", prefix)
+		}
+
+		route.Match = bootstrap.NewPrefixMatch(prefix)
+	default:
+		route.Match = bootstrap.NewPrefixMatch("/")
+	}
+
+	redirect := fields["redirect"].Value
+
+	status := int64(200)
+	if redirect != "" {
+		status = 301
+	}
+
+	if !fields["status"].IsZero() {
+		var err error
+		if status, err = fields["status"].AsInt64(); err != nil {
+			return nil, err
+		}
+	}
+
+	if redirect != "" {
+		if !fields["body"].IsZero() || !fields["content_type"].IsZero() {
+			return nil, fmt.Errorf("redirects cannot have a body")
+		}
+
+		action, err := newRedirectAction(redirect, status)
+		if err != nil {
+			return nil, err
+		}
+
+		route.Action = &envoy_config_route_v3.Route_Redirect{Redirect: action}
+		return route, nil
+	}
+
+	if status < 200 || status > 599 {
+		return nil, fmt.Errorf("invalid status %d (must be 200-599)", status)
+	}
+
+	response := &bootstrap.DirectResponseAction{
+		Status: uint32(status),
+	}
+
+	if body := fields["body"].Value; body != "" {
+		response.Body = bootstrap.NewInlineString(body)
+	}
+
+	if contentType := fields["content_type"].Value; contentType != "" {
+		route.ResponseHeadersToAdd = []*envoy_config_core_v3.HeaderValueOption{
+			&envoy_config_core_v3.HeaderValueOption{
+				Header: &envoy_config_core_v3.HeaderValue{Key: "content-type", Value: contentType},
+				Append: bootstrap.False(),
+			},
+		}
+	}
+
+	route.Action = &envoy_config_route_v3.Route_DirectResponse{DirectResponse: response}
+	return route, nil
+}
+
+// HackRespond builds an HTTP listener that answers requests itself,
+// with a fixed status and body or with a redirect. Each of the
+// "routes" parameters is a map of the same fields as the hack
+// parameters, so a single listener can, for example, answer health
+// checks and redirect everything else to HTTPS.
+func HackRespond(_ Env, spec Spec) (xds.Snapshot, error) {
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	hostList, err := spec.Parameters["host"].AsList()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	// Envoy rejects a virtual host with duplicate domains, so only
+	// add each host (and its ":*" variant) once.
+	var domains []string
+	seen := map[string]bool{}
+
+	addDomain := func(d string) {
+		if !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+
+	for _, h := range hostList {
+		if h.Value == "" {
+			return xds.Snapshot{}, fmt.Errorf("empty host name")
+		}
+
+		addDomain(h.Value)
+		if h.Value != "*" && !strings.Contains(h.Value, ":") {
+			addDomain(h.Value + ":*")
+		}
+	}
+
+	if len(domains) == 0 {
+		return xds.Snapshot{}, fmt.Errorf("at least one host name is required")
+	}
+
+	overrides, err := spec.Parameters["routes"].AsList()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	var routes []*bootstrap.Route
+
+	for i, o := range overrides {
+		fields, err := o.AsMap()
+		if err != nil {
+			return xds.Snapshot{}, fmt.Errorf("route %d: %w", i, err)
+		}
+
+		r, err := newRespondRoute(fields)
+		if err != nil {
+			return xds.Snapshot{}, fmt.Errorf("route %d: %w", i, err)
+		}
+
+		routes = append(routes, r)
+	}
+
+	fields := map[string]Parameter{}
+	for _, name := range []string{"prefix", "path", "status", "body", "content_type", "redirect"} {
+		if p, ok := spec.Parameters[name]; ok {
+			fields[name] = p
+		}
+	}
+
+	r, err := newRespondRoute(fields)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	routes = append(routes, r)
+
+	listenerName := fmt.Sprintf("hack/respond/listener/%d", port)
+	routeName := fmt.Sprintf("hack/respond/route/%d", port)
+
+	listener := NewTCPListener(listenerName, addr, port,
+		NewHTTPConnectionManager(strings.Replace(listenerName, "/", "-", -1), routeName))
+
+	config := &bootstrap.RouteConfiguration{
+		Name: routeName,
+		VirtualHosts: []*bootstrap.VirtualHost{
+			&bootstrap.VirtualHost{
+				Name:    routeName,
+				Domains: domains,
+				Routes:  routes,
+			},
+		},
+	}
+
+	// Envoy rejects direct response bodies over 4KB unless the
+	// route configuration raises the limit.
+	maxBody := 0
+	for _, r := range routes {
+		if n := len(r.GetDirectResponse().GetBody().GetInlineString()); n > maxBody {
+			maxBody = n
+		}
+	}
+
+	if maxBody > 4096 {
+		config.MaxDirectResponseBodySizeBytes = bootstrap.UInt32(uint32(maxBody))
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), config)
+
+	return snap, nil
+}
@@ -0,0 +1,160 @@
+package hacks
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/endpoints"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	protov1 "github.com/golang/protobuf/proto"
+)
+
+func init() {
+	Register(New("scale",
+		"Large numbers of listeners, routes, clusters and endpoints for config scale testing",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Default: "127.0.0.1", Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Default: "20000", Help: "Port of the first listener"},
+			{Name: "listeners", Type: IntParameter, Default: "1", Help: "Number of listeners, each with its own route configuration"},
+			{Name: "vhosts", Type: IntParameter, Default: "1", Help: "Number of virtual hosts per route configuration"},
+			{Name: "routes", Type: IntParameter, Default: "1", Help: "Number of routes per virtual host"},
+			{Name: "clusters", Type: IntParameter, Default: "1", Help: "Number of clusters"},
+			{Name: "endpoints", Type: IntParameter, Default: "1", Help: "Number of endpoints per cluster"},
+			{Name: "endpoint_address", Type: IPParameter, Default: "127.0.0.1", Help: "IP address of the endpoints"},
+			{Name: "endpoint_port", Type: PortParameter, Default: "30000", Help: "Port of the first endpoint in each cluster"},
+		},
+		HackScale,
+	))
+}
+
+// snapshotSize returns the total marshaled size of the resources
+// of the given type.
+func snapshotSize(snap *xds.Snapshot, t xds.ResponseType) int {
+	size := 0
+	for _, r := range snap.Resources[t].Items {
+		size += protov1.Size(r.Resource)
+	}
+
+	return size
+}
+
+// HackScale generates the given numbers of listeners, virtual hosts,
+// routes, clusters and endpoints. Names and ports are derived from
+// the index of each resource, so that the same parameters always
+// generate the same configuration. Routes are spread across the
+// clusters in round-robin order.
+func HackScale(_ Env, spec Spec) (xds.Snapshot, error) {
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	endpointAddr, err := spec.Parameters["endpoint_address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	counts := map[string]int64{}
+	for _, name := range []string{"port", "listeners", "vhosts", "routes", "clusters", "endpoints", "endpoint_port"} {
+		n, err := spec.Parameters[name].AsInt64()
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		if n < 1 {
+			return xds.Snapshot{}, fmt.Errorf("parameter %q must be at least 1", name)
+		}
+
+		counts[name] = n
+	}
+
+	if counts["port"]+counts["listeners"]-1 > 65535 {
+		return xds.Snapshot{}, fmt.Errorf("%d listeners from port %d exceed the port range",
+			counts["listeners"], counts["port"])
+	}
+
+	if counts["endpoint_port"]+counts["endpoints"]-1 > 65535 {
+		return xds.Snapshot{}, fmt.Errorf("%d endpoints from port %d exceed the port range",
+			counts["endpoints"], counts["endpoint_port"])
+	}
+
+	assignments := map[string]*bootstrap.ClusterLoadAssignment{}
+	var clusterNames []string
+
+	for c := int64(0); c < counts["clusters"]; c++ {
+		name := fmt.Sprintf("hack/scale/cluster/%d", c)
+
+		var eps []endpoints.Endpoint
+		for e := int64(0); e < counts["endpoints"]; e++ {
+			eps = append(eps, endpoints.Endpoint{
+				Address: endpointAddr.String(),
+				Port:    uint32(counts["endpoint_port"] + e),
+			})
+		}
+
+		cla, err := endpoints.NewLoadAssignment(name, eps)
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		assignments[name] = cla
+		clusterNames = append(clusterNames, name)
+	}
+
+	var listeners []protov1.Message
+	var routeConfigs []protov1.Message
+
+	next := 0
+
+	for l := int64(0); l < counts["listeners"]; l++ {
+		port := counts["port"] + l
+		listenerName := fmt.Sprintf("hack/scale/listener/%d", port)
+		routeName := fmt.Sprintf("hack/scale/route/%d", port)
+
+		listeners = append(listeners, NewTCPListener(listenerName, addr, port,
+			NewHTTPConnectionManager(fmt.Sprintf("hack-scale-%d", port), routeName)))
+
+		routes := &bootstrap.RouteConfiguration{
+			Name: routeName,
+		}
+
+		for v := int64(0); v < counts["vhosts"]; v++ {
+			hostname := fmt.Sprintf("scale-%d.example.com", v)
+			vhost := &bootstrap.VirtualHost{
+				Name:    hostname,
+				Domains: []string{hostname, hostname + ":*"},
+			}
+
+			for r := int64(0); r < counts["routes"]; r++ {
+				vhost.Routes = append(vhost.Routes,
+					bootstrap.NewClusterRoute(fmt.Sprintf("/route-%d", r), clusterNames[next%len(clusterNames)]))
+				next++
+			}
+
+			routes.VirtualHosts = append(routes.VirtualHosts, vhost)
+		}
+
+		routeConfigs = append(routeConfigs, routes)
+	}
+
+	snap := endpoints.Snapshot(assignments)
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listeners...)
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), routeConfigs...)
+
+	sizes := map[xds.ResponseType]int{}
+	total := 0
+	for _, t := range []xds.ResponseType{xds.ListenerType, xds.RouteType, xds.ClusterType, xds.EndpointType} {
+		sizes[t] = snapshotSize(&snap, t)
+		total += sizes[t]
+	}
+
+	log.Printf("scale: %d listeners, %d routes, %d clusters, %d endpoints, %d bytes "+
+		"(listeners %d, routes %d, clusters %d, endpoints %d)",
+		counts["listeners"], counts["listeners"]*counts["vhosts"]*counts["routes"],
+		counts["clusters"], counts["clusters"]*counts["endpoints"], total,
+		sizes[xds.ListenerType], sizes[xds.RouteType], sizes[xds.ClusterType], sizes[xds.EndpointType])
+
+	return snap, nil
+}
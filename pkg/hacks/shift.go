@@ -0,0 +1,307 @@
+package hacks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/admin"
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/endpoints"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	protov1 "github.com/golang/protobuf/proto"
+)
+
+func init() {
+	Register(New("shift",
+		"HTTP listener that shifts traffic between two clusters in steps",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "from", Type: StringParameter, Required: true, Help: "Cluster or upstream address to shift traffic from"},
+			{Name: "to", Type: StringParameter, Required: true, Help: "Cluster or upstream address to shift traffic to"},
+			{Name: "start", Type: IntParameter, Default: "0", Help: "Initial percentage of traffic sent to the \"to\" cluster"},
+			{Name: "step", Type: IntParameter, Default: "10", Help: "Percentage of traffic to shift at each step"},
+			{Name: "interval", Type: DurationParameter, Default: "30s", Help: "Interval between steps"},
+			{Name: "stat", Type: StringParameter, Default: "upstream_rq_5xx",
+				Help: "Counter of the \"to\" cluster that is checked against the threshold"},
+			{Name: "threshold", Type: FloatParameter, Default: "0",
+				Help: "Percentage of \"to\" cluster requests that the stat can reach in a step (0 disables the check)"},
+			{Name: "min_requests", Type: IntParameter, Default: "10",
+				Help: "Minimum number of \"to\" cluster requests in a step for the threshold to apply"},
+			{Name: "on_threshold", Type: StringParameter, Default: "pause",
+				Help: `What to do when the threshold is crossed ("pause" or "rollback")`},
+		},
+		HackShift,
+	))
+}
+
+// newShiftTarget returns the cluster for a shift target, which is
+// either an upstream address or a cluster name. An upstream address
+// gets a static cluster with the given name.
+func newShiftTarget(p Parameter, clusterName string) (string, []protov1.Message, error) {
+	if host, _, err := endpoints.ParseHostPort(p.Value); err != nil || net.ParseIP(host) == nil {
+		return p.Value, nil, nil
+	}
+
+	upstream, err := p.TCPAddr()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return clusterName, []protov1.Message{
+		bootstrap.NewStaticCluster(clusterName, bootstrap.NewTCPAddress(upstream)),
+	}, nil
+}
+
+// HackShift builds an HTTP listener that splits requests between the
+// "from" and "to" clusters by weight. While Envoy runs, the weight of
+// the "to" cluster grows by a step at each interval, publishing a new
+// route configuration each time, until it gets all the traffic.
+//
+// If a threshold is given, the stat of the "to" cluster is read from
+// the Envoy admin API before each step. When the stat grows by more
+// than the threshold percentage of the requests to the cluster, the
+// shift either pauses until the next interval or rolls back all the
+// traffic to the "from" cluster.
+func HackShift(env Env, spec Spec) (xds.Snapshot, error) {
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	start, err := spec.Parameters["start"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if start < 0 || start > 100 {
+		return xds.Snapshot{}, fmt.Errorf("invalid start %d (must be 0-100)", start)
+	}
+
+	step, err := spec.Parameters["step"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if step < 1 || step > 100 {
+		return xds.Snapshot{}, fmt.Errorf("invalid step %d (must be 1-100)", step)
+	}
+
+	interval, err := spec.Parameters["interval"].AsDuration()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if interval <= 0 {
+		return xds.Snapshot{}, fmt.Errorf("invalid interval %s", interval)
+	}
+
+	threshold, err := spec.Parameters["threshold"].AsFloat64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if threshold < 0 || threshold > 100 {
+		return xds.Snapshot{}, fmt.Errorf("invalid threshold %v (must be 0-100)", threshold)
+	}
+
+	minRequests, err := spec.Parameters["min_requests"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	var rollback bool
+
+	switch action := spec.Parameters["on_threshold"].Value; action {
+	case "pause":
+	case "rollback":
+		rollback = true
+	default:
+		return xds.Snapshot{}, fmt.Errorf("invalid on_threshold %q (must be pause or rollback)", action)
+	}
+
+	listenerName := fmt.Sprintf("hack/shift/listener/%d", port)
+	routeName := fmt.Sprintf("hack/shift/route/%d", port)
+
+	from, fromClusters, err := newShiftTarget(spec.Parameters["from"], fmt.Sprintf("hack/shift/cluster/%d/from", port))
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	to, toClusters, err := newShiftTarget(spec.Parameters["to"], fmt.Sprintf("hack/shift/cluster/%d/to", port))
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if from == to {
+		return xds.Snapshot{}, fmt.Errorf("the from and to clusters must be different")
+	}
+
+	listener := NewTCPListener(listenerName, addr, port,
+		NewHTTPConnectionManager(strings.Replace(listenerName, "/", "-", -1), routeName))
+	clusters := append(fromClusters, toClusters...)
+
+	// newSnapshot returns the snapshot with the given percentage
+	// of requests routed to the "to" cluster.
+	newSnapshot := func(percent int64) xds.Snapshot {
+		route := bootstrap.NewWeightedClusterRoute("/",
+			&bootstrap.ClusterWeight{Name: from, Weight: bootstrap.UInt32(uint32(100 - percent))},
+			&bootstrap.ClusterWeight{Name: to, Weight: bootstrap.UInt32(uint32(percent))},
+		)
+
+		snap := xds.Snapshot{}
+		snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+		snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), NewRouteConfiguration(routeName, route))
+		snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+		return snap
+	}
+
+	if env.Go != nil && start < 100 {
+		s := shift{
+			port:        port,
+			from:        from,
+			to:          to,
+			start:       start,
+			step:        step,
+			interval:    interval,
+			stat:        spec.Parameters["stat"].Value,
+			threshold:   threshold,
+			minRequests: minRequests,
+			rollback:    rollback,
+			admin:       admin.NewClient(path.Join(env.RunDir, admin.SocketName)),
+			newSnapshot: newSnapshot,
+		}
+
+		env.Go(s.run)
+	}
+
+	return newSnapshot(start), nil
+}
+
+// shift is the background task of a shift hack.
+type shift struct {
+	port        int64
+	from        string
+	to          string
+	start       int64
+	step        int64
+	interval    time.Duration
+	stat        string
+	threshold   float64
+	minRequests int64
+	rollback    bool
+	admin       *admin.Client
+	newSnapshot func(int64) xds.Snapshot
+}
+
+// counterDelta returns how much the named counter grew between the
+// last and current stats. Envoy creates some counters when they are
+// first incremented, so a counter that is in neither is zero. There is
+// no delta if there are no last stats, or if the counter went missing
+// or went backwards, since then it was reset, e.g. by an Envoy restart.
+func counterDelta(last map[string]uint64, stats map[string]uint64, name string) (uint64, bool) {
+	if last == nil {
+		return 0, false
+	}
+
+	before, hadBefore := last[name]
+	now, ok := stats[name]
+
+	if hadBefore && (!ok || now < before) {
+		return 0, false
+	}
+
+	return now - before, true
+}
+
+// run moves the traffic to the "to" cluster one step at a time.
+func (s *shift) run(ctx context.Context, update func(xds.Snapshot) error) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	total := fmt.Sprintf("cluster.%s.upstream_rq_total", s.to)
+	watched := fmt.Sprintf("cluster.%s.%s", s.to, s.stat)
+	filter := fmt.Sprintf("^(%s|%s)$", regexp.QuoteMeta(total), regexp.QuoteMeta(watched))
+
+	var last map[string]uint64
+	if s.threshold > 0 {
+		// If Envoy isn't ready yet, there is no baseline, so
+		// the first step is skipped.
+		last, _ = s.admin.Stats(ctx, filter)
+	}
+
+	percent := s.start
+
+	for percent < 100 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if s.threshold > 0 {
+			stats, err := s.admin.Stats(ctx, filter)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				log.Printf("shift: port %d: pausing at %d%%: %s", s.port, percent, err)
+				continue
+			}
+
+			requests, requestsOK := counterDelta(last, stats, total)
+			count, countOK := counterDelta(last, stats, watched)
+			last = stats
+
+			if !requestsOK || !countOK {
+				log.Printf("shift: port %d: no baseline for %s stats, pausing at %d%%", s.port, s.to, percent)
+				continue
+			}
+
+			if rate := 100 * float64(count) / float64(requests); requests > 0 &&
+				int64(requests) >= s.minRequests && rate > s.threshold {
+				if s.rollback {
+					if err := update(s.newSnapshot(0)); err != nil {
+						log.Printf("shift: port %d: failed to roll back: %s", s.port, err)
+						return
+					}
+
+					log.Printf("shift: port %d: %s is %.1f%% of %d requests, rolled back all traffic to %s",
+						s.port, s.stat, rate, requests, s.from)
+					return
+				}
+
+				log.Printf("shift: port %d: %s is %.1f%% of %d requests, pausing at %d%%",
+					s.port, s.stat, rate, requests, percent)
+				continue
+			}
+		}
+
+		percent += s.step
+		if percent > 100 {
+			percent = 100
+		}
+
+		if err := update(s.newSnapshot(percent)); err != nil {
+			log.Printf("shift: port %d: failed to shift to %d%%: %s", s.port, percent, err)
+			return
+		}
+
+		log.Printf("shift: port %d: %s %d%%, %s %d%%", s.port, s.from, 100-percent, s.to, percent)
+	}
+
+	log.Printf("shift: port %d: all traffic shifted to %s", s.port, s.to)
+}
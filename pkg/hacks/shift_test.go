@@ -0,0 +1,28 @@
+package hacks
+
+import (
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		last  map[string]uint64
+		stats map[string]uint64
+		delta uint64
+		ok    bool
+	}{
+		{name: "grew", last: map[string]uint64{"c": 5}, stats: map[string]uint64{"c": 8}, delta: 3, ok: true},
+		{name: "same", last: map[string]uint64{"c": 5}, stats: map[string]uint64{"c": 5}, delta: 0, ok: true},
+		{name: "not created", last: map[string]uint64{}, stats: map[string]uint64{}, delta: 0, ok: true},
+		{name: "created", last: map[string]uint64{}, stats: map[string]uint64{"c": 2}, delta: 2, ok: true},
+		{name: "no baseline", last: nil, stats: map[string]uint64{"c": 2}},
+		{name: "went backwards", last: map[string]uint64{"c": 5}, stats: map[string]uint64{"c": 1}},
+		{name: "went missing", last: map[string]uint64{"c": 5}, stats: map[string]uint64{}},
+	} {
+		delta, ok := counterDelta(tc.last, tc.stats, "c")
+		if delta != tc.delta || ok != tc.ok {
+			t.Fatalf("%s: got %d, %v, want %d, %v", tc.name, delta, ok, tc.delta, tc.ok)
+		}
+	}
+}
@@ -1,19 +1,60 @@
 package hacks
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
 )
 
-// Parameter is a param value represented as a string.
-type Parameter string
+// Parameter is a hack parameter value. A parameter is a scalar
+// string, a list of parameters or a map of named parameters.
+type Parameter struct {
+	// Value is the value of a scalar parameter.
+	Value string
+	// List is the elements of a list parameter.
+	List []Parameter
+	// Fields is the named fields of a map parameter.
+	Fields map[string]Parameter
+}
+
+// NewParameter returns a scalar parameter.
+func NewParameter(value string) Parameter {
+	return Parameter{Value: value}
+}
+
+// IsList returns true if the parameter is a list.
+func (p Parameter) IsList() bool {
+	return p.List != nil
+}
+
+// IsMap returns true if the parameter is a map.
+func (p Parameter) IsMap() bool {
+	return p.Fields != nil
+}
+
+// IsScalar returns true if the parameter is a scalar string.
+func (p Parameter) IsScalar() bool {
+	return !p.IsList() && !p.IsMap()
+}
+
+// IsZero returns true if the parameter is an empty scalar.
+func (p Parameter) IsZero() bool {
+	return p.IsScalar() && p.Value == ""
+}
 
 // Or returns the default if the parameter is empty.
 func (p Parameter) Or(or string) Parameter {
-	if p == "" {
-		return Parameter(or)
+	if p.IsZero() {
+		return NewParameter(or)
 	}
 
 	return p
@@ -21,70 +62,536 @@ func (p Parameter) Or(or string) Parameter {
 
 // AsString ...
 func (p Parameter) AsString() (string, error) {
-	return string(p), nil
+	if !p.IsScalar() {
+		return "", fmt.Errorf("expected a scalar value, not %s", p)
+	}
+
+	return p.Value, nil
 }
 
 // AsBool ...
 func (p Parameter) AsBool() (bool, error) {
-	return strconv.ParseBool(string(p))
+	s, err := p.AsString()
+	if err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(s)
 }
 
 // AsInt64 ...
 func (p Parameter) AsInt64() (int64, error) {
-	return strconv.ParseInt(string(p), 10, 32)
+	s, err := p.AsString()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(s, 10, 32)
+}
+
+// AsFloat64 ...
+func (p Parameter) AsFloat64() (float64, error) {
+	s, err := p.AsString()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
+
+// AsDuration ...
+func (p Parameter) AsDuration() (time.Duration, error) {
+	s, err := p.AsString()
+	if err != nil {
+		return 0, err
+	}
+
+	return time.ParseDuration(s)
 }
 
 // IP ...
 func (p Parameter) IP() (net.IP, error) {
-	ip := net.ParseIP(string(p))
+	s, err := p.AsString()
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(s)
 	if ip == nil {
-		return nil, fmt.Errorf("invalid IP address %q", string(p))
+		return nil, fmt.Errorf("invalid IP address %q", s)
 	}
 
 	return ip, nil
 }
 
+// TCPAddr ...
+func (p Parameter) TCPAddr() (*net.TCPAddr, error) {
+	s, err := p.AsString()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP address %q: %w", s, err)
+	}
+
+	if addr.IP == nil {
+		return nil, fmt.Errorf("invalid TCP address %q: missing IP address", s)
+	}
+
+	return addr, nil
+}
+
+// AsList returns the elements of a list parameter. A non-empty
+// scalar is treated as a list of one element.
+func (p Parameter) AsList() ([]Parameter, error) {
+	switch {
+	case p.IsList():
+		return p.List, nil
+	case p.IsMap():
+		return nil, fmt.Errorf("expected a list value, not %s", p)
+	case p.Value == "":
+		return nil, nil
+	default:
+		return []Parameter{p}, nil
+	}
+}
+
+// AsMap returns the fields of a map parameter. An empty scalar is
+// treated as an empty map.
+func (p Parameter) AsMap() (map[string]Parameter, error) {
+	switch {
+	case p.IsMap():
+		return p.Fields, nil
+	case p.IsZero():
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("expected a map value, not %s", p)
+	}
+}
+
+// String formats the parameter in spec syntax, quoting it if
+// necessary.
+func (p Parameter) String() string {
+	switch {
+	case p.IsList():
+		var elems []string
+		for _, e := range p.List {
+			elems = append(elems, e.String())
+		}
+
+		return "[" + strings.Join(elems, ",") + "]"
+	case p.IsMap():
+		return "{" + formatParameters(p.Fields) + "}"
+	default:
+		return quote(p.Value)
+	}
+}
+
+// MarshalJSON encodes scalars as JSON strings, lists as arrays and
+// maps as objects.
+func (p Parameter) MarshalJSON() ([]byte, error) {
+	switch {
+	case p.IsList():
+		return json.Marshal(p.List)
+	case p.IsMap():
+		return json.Marshal(p.Fields)
+	default:
+		return json.Marshal(p.Value)
+	}
+}
+
+// UnmarshalJSON decodes a parameter from JSON. Numbers and booleans
+// are kept as scalars in their JSON text form.
+func (p *Parameter) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	switch {
+	case bytes.HasPrefix(data, []byte("[")):
+		list := []Parameter{}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+
+		*p = Parameter{List: list}
+	case bytes.HasPrefix(data, []byte("{")):
+		fields := map[string]Parameter{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+
+		*p = Parameter{Fields: fields}
+	case bytes.HasPrefix(data, []byte(`"`)):
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+
+		*p = NewParameter(s)
+	case bytes.Equal(data, []byte("null")):
+		*p = Parameter{}
+	default:
+		*p = NewParameter(string(data))
+	}
+
+	return nil
+}
+
 // Spec describes a parameterized hack to run.
 type Spec struct {
-	Hack       string
-	Parameters map[string]Parameter
+	Hack       string               `json:"hack"`
+	Parameters map[string]Parameter `json:"params,omitempty"`
+}
+
+// ReadFileRefs replaces each scalar parameter value that starts with
+// "@" with the contents of the named file. Relative paths are resolved
+// from the given directory. A value that starts with "@@" is not a
+// file reference, and is replaced by the value without the first "@".
+func (s Spec) ReadFileRefs(dir string) error {
+	for name, param := range s.Parameters {
+		p, err := readFileRefs(dir, param)
+		if err != nil {
+			return fmt.Errorf("parameter %q: %w", name, err)
+		}
+
+		s.Parameters[name] = p
+	}
+
+	return nil
+}
+
+// String formats the spec in the syntax accepted by ParseSpec.
+func (s Spec) String() string {
+	if len(s.Parameters) == 0 {
+		return s.Hack
+	}
+
+	return s.Hack + ":" + formatParameters(s.Parameters)
 }
 
-// ParseSpec parses a policy specification string. A policy
+func formatParameters(params map[string]Parameter) string {
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var fields []string
+	for _, name := range names {
+		fields = append(fields, name+"="+params[name].String())
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// special is the set of characters that terminate a bare word.
+const special = `,=[]{}"'`
+
+// quote returns the string as a bare word if that would parse back
+// to the same string, and double-quotes it otherwise.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, special) {
+		return s
+	}
+
+	return strconv.Quote(s)
+}
+
+// ParseSpec parses a hack specification string. A hack
 // specification string is of the form:
 //
-//      OPERATION[:PARAM=VALUE[,PARAM=VALUE]...]
+//	HACK[:PARAM[=VALUE][,PARAM[=VALUE]]...]
+//
+// A parameter with no value is a boolean flag that is set to "true".
+// A value can be one of:
+//
+//	word          a bare word, ending at the next ",", "]" or "}"
+//	"text"        a double-quoted string with Go escape sequences
+//	'text'        a single-quoted string with no escapes
+//	[V,V,...]     a list of values
+//	{P=V,P=V,...} a map of named values
+//
+// Values that contain special characters, or start with "[" or "{",
+// must be quoted, e.g. upstream="[::1]:8080".
+//
+// ParseSpec doesn't read any files. A value of the form "@path" is
+// kept as it is, until Spec.ReadFileRefs replaces it with the contents
+// of the file at path. A literal value that starts with "@" is written
+// with "@@", e.g. header=@@user.
 func ParseSpec(specString string) (Spec, error) {
 	if specString == "" {
-		return Spec{}, fmt.Errorf("empty policy specification")
+		return Spec{}, fmt.Errorf("empty hack specification")
 	}
 
+	p := parser{input: specString}
+
 	spec := Spec{
-		Parameters: make(map[string]Parameter),
+		Parameters: map[string]Parameter{},
+	}
+
+	// The hack name is everything up to the first ":".
+	if i := strings.IndexByte(specString, ':'); i >= 0 {
+		spec.Hack = specString[:i]
+		p.pos = i + 1
+	} else {
+		spec.Hack = specString
+		p.pos = len(specString)
 	}
 
-	// Outer syntax is "$OP:$ARGS".
-	parts := strings.SplitN(specString, ":", 2)
-	spec.Hack = parts[0]
+	if spec.Hack == "" || strings.ContainsAny(spec.Hack, special+" \t\n") {
+		return Spec{}, fmt.Errorf("invalid hack name %q", spec.Hack)
+	}
 
-	switch len(parts) {
-	case 1:
-		// Just an operation, with no policy args.
+	if p.done() {
 		return spec, nil
-	case 2:
-		for _, arg := range strings.Split(parts[1], ",") {
-			parts := strings.SplitN(arg, "=", 2)
-			switch len(parts) {
-			case 1:
-				// If no parameter is specified, it is implicitly a boolean flag.
-				spec.Parameters[parts[0]] = Parameter("true")
-			case 2:
-				spec.Parameters[parts[0]] = Parameter(parts[1])
+	}
+
+	params, err := p.parameters("")
+	if err != nil {
+		return Spec{}, err
+	}
+
+	if !p.done() {
+		return Spec{}, p.errorf("unexpected %q", p.peek())
+	}
+
+	spec.Parameters = params
+	return spec, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// parameters parses a comma-separated list of parameters, up to
+// the end of the input, or up to the given terminator.
+func (p *parser) parameters(term string) (map[string]Parameter, error) {
+	params := map[string]Parameter{}
+
+	if term != "" && p.peek() == term[0] {
+		return params, nil
+	}
+
+	for {
+		start := p.pos
+		for !p.done() && !strings.ContainsRune(special, rune(p.peek())) {
+			p.pos++
+		}
+
+		name := p.input[start:p.pos]
+		if name == "" {
+			return nil, p.errorf("missing parameter name")
+		}
+
+		if _, ok := params[name]; ok {
+			return nil, p.errorf("duplicate parameter %q", name)
+		}
+
+		if p.peek() == '=' {
+			p.pos++
+
+			val, err := p.value()
+			if err != nil {
+				return nil, err
 			}
+
+			params[name] = val
+		} else {
+			// If no value is specified, it is implicitly a boolean flag.
+			params[name] = NewParameter("true")
 		}
 
-		return spec, nil
+		if p.peek() != ',' {
+			return params, nil
+		}
+
+		p.pos++
+	}
+}
+
+// value parses a single parameter value.
+func (p *parser) value() (Parameter, error) {
+	switch p.peek() {
+	case '[':
+		p.pos++
+
+		list := []Parameter{}
+		for p.peek() != ']' {
+			val, err := p.value()
+			if err != nil {
+				return Parameter{}, err
+			}
+
+			list = append(list, val)
+
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != ']' {
+				return Parameter{}, p.errorf("unterminated list")
+			}
+		}
+
+		p.pos++
+		return Parameter{List: list}, nil
+
+	case '{':
+		p.pos++
+
+		fields, err := p.parameters("}")
+		if err != nil {
+			return Parameter{}, err
+		}
+
+		if p.peek() != '}' {
+			return Parameter{}, p.errorf("unterminated map")
+		}
+
+		p.pos++
+		return Parameter{Fields: fields}, nil
+
+	case '"':
+		start := p.pos
+		for p.pos++; ; p.pos++ {
+			switch p.peek() {
+			case 0:
+				if p.done() {
+					p.pos = start
+					return Parameter{}, p.errorf("unterminated quoted string")
+				}
+			case '\\':
+				p.pos++
+			case '"':
+				p.pos++
+
+				s, err := strconv.Unquote(p.input[start:p.pos])
+				if err != nil {
+					p.pos = start
+					return Parameter{}, p.errorf("invalid quoted string: %s", err)
+				}
+
+				return NewParameter(s), nil
+			}
+		}
+
+	case '\'':
+		start := p.pos + 1
+		end := strings.IndexByte(p.input[start:], '\'')
+		if end < 0 {
+			return Parameter{}, p.errorf("unterminated quoted string")
+		}
+
+		p.pos = start + end + 1
+		return NewParameter(p.input[start : start+end]), nil
+
 	default:
-		panic(fmt.Sprintf("invalid spec component %q", parts))
+		return NewParameter(p.word()), nil
+	}
+}
+
+// word parses a bare word. Bare words may contain "=", since only
+// the first "=" separates a parameter name from its value.
+func (p *parser) word() string {
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(`,]}`, rune(p.peek())) {
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+// ReadSpecFile reads a YAML file containing a list of hack specs,
+// e.g.:
+//
+//	# hacks.yaml
+//	- hack: lua
+//	  params:
+//	    address: 127.0.0.1
+//	    port: 8080
+//	    script: "@filter.lua"
+//
+// As with Spec.ReadFileRefs, a scalar value that starts with "@" is
+// replaced with the contents of the named file. Relative paths are
+// resolved from the directory containing the spec file.
+func ReadSpecFile(path string) ([]Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []Spec
+	if err := yaml.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, s := range specs {
+		if s.Hack == "" {
+			return nil, fmt.Errorf("%s: entry %d: missing hack name", path, i)
+		}
+
+		if s.Parameters == nil {
+			specs[i].Parameters = map[string]Parameter{}
+		}
+
+		if err := specs[i].ReadFileRefs(filepath.Dir(path)); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, i, err)
+		}
 	}
+
+	return specs, nil
+}
+
+// readFileRefs replaces each "@path" scalar in the parameter with
+// the contents of the file, and unescapes each "@@" scalar.
+func readFileRefs(dir string, p Parameter) (Parameter, error) {
+	switch {
+	case p.IsList():
+		for i, e := range p.List {
+			var err error
+			if p.List[i], err = readFileRefs(dir, e); err != nil {
+				return Parameter{}, err
+			}
+		}
+	case p.IsMap():
+		for name, f := range p.Fields {
+			var err error
+			if p.Fields[name], err = readFileRefs(dir, f); err != nil {
+				return Parameter{}, err
+			}
+		}
+	case strings.HasPrefix(p.Value, "@@"):
+		return NewParameter(p.Value[1:]), nil
+	case strings.HasPrefix(p.Value, "@"):
+		path := p.Value[1:]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Parameter{}, err
+		}
+
+		return NewParameter(string(data)), nil
+	}
+
+	return p, nil
 }
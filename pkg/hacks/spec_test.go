@@ -0,0 +1,129 @@
+//go:build go1.18
+// +build go1.18
+
+package hacks
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func FuzzParseSpec(f *testing.F) {
+	for _, s := range []string{
+		"lua",
+		"lua:",
+		"tcpproxy:address=127.0.0.1,port=8080,name=foo",
+		"tcpproxy:address=::1,port=8080,keepalive=true",
+		"ratelimit:descriptor=header:x-user,freebind",
+		`extauthz:upstream="[::1]:9000",failopen`,
+		`lua:code='request_handle:logInfo("hi")'`,
+		`lua:code="a,b\n\"c\""`,
+		"tcpproxy:upstreams=[127.0.0.1:80,127.0.0.2:80]",
+		"tcpproxy:upstreams=[{address=127.0.0.1:80,weight=70},{address=127.0.0.2:80,weight=30}]",
+		"scale:nested={a=[],b={},c=[[x],[y,z]]}",
+		"lua:script=@filter.lua",
+		`lua:script="@filter.lua"`,
+		"lua:header=@@user",
+		"lua:a=b,",
+		"lua:a=b,a=c",
+		"lua:a=[b",
+		`lua:a="b`,
+		"lua:a={b=c",
+		":a=b",
+	} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		spec, err := ParseSpec(s)
+		if err != nil {
+			return
+		}
+
+		// A parsed spec must format to a string that parses
+		// back to the same spec.
+		formatted := spec.String()
+
+		again, err := ParseSpec(formatted)
+		if err != nil {
+			t.Fatalf("failed to parse %q (formatted from %q): %s", formatted, s, err)
+		}
+
+		if !reflect.DeepEqual(spec, again) {
+			t.Fatalf("%q (formatted from %q) parsed to %#v, want %#v", formatted, s, again, spec)
+		}
+	})
+}
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec(`tcpproxy:address=::1,upstreams=[a:70,"b,c"],opts={idle=1h,flags=[x]},freebind`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Spec{
+		Hack: "tcpproxy",
+		Parameters: map[string]Parameter{
+			"address":   NewParameter("::1"),
+			"upstreams": {List: []Parameter{NewParameter("a:70"), NewParameter("b,c")}},
+			"opts": {Fields: map[string]Parameter{
+				"idle":  NewParameter("1h"),
+				"flags": {List: []Parameter{NewParameter("x")}},
+			}},
+			"freebind": NewParameter("true"),
+		},
+	}
+
+	if !reflect.DeepEqual(spec, want) {
+		t.Fatalf("got %#v, want %#v", spec, want)
+	}
+}
+
+func TestReadFileRefs(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "filter.lua"), []byte("-- filter"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := ParseSpec("lua:script=@filter.lua,routes=[{prefix=/foo,script=@filter.lua,header=@@user}],name=@@@x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parsing must not read the file.
+	if got := spec.Parameters["script"].Value; got != "@filter.lua" {
+		t.Fatalf("got script %q, want %q", got, "@filter.lua")
+	}
+
+	if err := spec.ReadFileRefs(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Spec{
+		Hack: "lua",
+		Parameters: map[string]Parameter{
+			"script": NewParameter("-- filter"),
+			"routes": {List: []Parameter{{Fields: map[string]Parameter{
+				"prefix": NewParameter("/foo"),
+				"script": NewParameter("-- filter"),
+				"header": NewParameter("@user"),
+			}}}},
+			"name": NewParameter("@@x"),
+		},
+	}
+
+	if !reflect.DeepEqual(spec, want) {
+		t.Fatalf("got %#v, want %#v", spec, want)
+	}
+
+	spec, err = ParseSpec("lua:script=@missing.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spec.ReadFileRefs(dir); err == nil {
+		t.Fatalf("got no error for a missing file")
+	}
+}
@@ -2,10 +2,13 @@ package hacks
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/jpeach/envoy-bootstrap/pkg/must"
+	"github.com/jpeach/envoy-bootstrap/pkg/accesslog"
+	"github.com/jpeach/envoy-bootstrap/pkg/endpoints"
 	"github.com/jpeach/envoy-bootstrap/pkg/xds"
 
 	envoy_extensions_filters_network_tcp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
@@ -13,17 +16,230 @@ import (
 	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
 )
 
-// HackTCPProxy ...
-func HackTCPProxy(spec Spec) xds.Snapshot {
-	addr := must.IP(spec.Parameters["address"].IP())
-	name := must.String(spec.Parameters["name"].AsString())
-	port := must.Int64(spec.Parameters["port"].AsInt64())
-	cluster := must.String(spec.Parameters["cluster"].AsString())
+func init() {
+	Register(New("tcpproxy",
+		"TCP proxy listener that forwards connections to generated or existing clusters",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "name", Type: StringParameter, Help: "Listener name (defaults to hack/tcpproxy/listener/ADDRESS:PORT)"},
+			{Name: "cluster", Type: StringParameter, Help: "Existing cluster to forward connections to, e.g. backend/tcp"},
+			{Name: "upstream", Type: ListParameter, Help: "Upstream endpoint addresses of a generated cluster"},
+			{Name: "upstreams", Type: ListParameter,
+				Help: "Weighted upstreams, e.g. 127.0.0.1:9000:70;backend/tcp:30 (each is an address or a cluster name)"},
+			{Name: "idle_timeout", Type: DurationParameter, Default: "1h", Help: "Idle connection timeout"},
+			{Name: "max_connect_attempts", Type: IntParameter, Default: "5", Help: "Maximum upstream connection attempts"},
+			{Name: "access_log", Type: StringParameter, Help: "Path to write connection access logs to, e.g. /dev/stdout"},
+			{Name: "keepalive", Type: BoolParameter, Default: "false", Help: "Enable TCP keepalive on downstream connections"},
+		},
+		HackTCPProxy,
+	))
+}
+
+// newUpstreamEndpoints parses a list of upstream endpoint addresses.
+func newUpstreamEndpoints(addrs ...string) ([]endpoints.Endpoint, error) {
+	var result []endpoints.Endpoint
+
+	for _, a := range addrs {
+		host, port, err := endpoints.ParseHostPort(a)
+		if err != nil {
+			return nil, err
+		}
+
+		if net.ParseIP(host) == nil || port == 0 {
+			return nil, fmt.Errorf("invalid upstream address %q", a)
+		}
+
+		result = append(result, endpoints.Endpoint{Address: host, Port: port})
+	}
+
+	return result, nil
+}
+
+type weightedCluster = envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_WeightedCluster_ClusterWeight
+
+// newTCPUpstreams returns the weighted clusters that the TCP proxy
+// forwards to, along with the load assignments of any clusters that
+// need to be generated. Generated clusters are named after the
+// listener address, so that proxies on the same port but different
+// addresses don't collide.
+func newTCPUpstreams(spec Spec, hostPort string) ([]*weightedCluster, map[string]*bootstrap.ClusterLoadAssignment, error) {
+	set := 0
+	for _, p := range []string{"cluster", "upstream", "upstreams"} {
+		if !spec.Parameters[p].IsZero() {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return nil, nil, fmt.Errorf("exactly one of the cluster, upstream or upstreams parameters is required")
+	}
+
+	var clusters []*weightedCluster
+	assignments := map[string]*bootstrap.ClusterLoadAssignment{}
+
+	if cluster := spec.Parameters["cluster"].Value; cluster != "" {
+		clusters = append(clusters, &weightedCluster{Name: cluster, Weight: 100})
+	}
+
+	upstream, err := spec.Parameters["upstream"].AsList()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(upstream) > 0 {
+		var addrs []string
+		for _, u := range upstream {
+			addrs = append(addrs, u.Value)
+		}
+
+		eps, err := newUpstreamEndpoints(addrs...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := fmt.Sprintf("hack/tcpproxy/cluster/%s", hostPort)
+		if assignments[name], err = endpoints.NewLoadAssignment(name, eps); err != nil {
+			return nil, nil, err
+		}
+
+		clusters = append(clusters, &weightedCluster{Name: name, Weight: 100})
+	}
+
+	upstreams, err := spec.Parameters["upstreams"].AsList()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var weighted []string
+	for _, u := range upstreams {
+		weighted = append(weighted, strings.Split(u.Value, ";")...)
+	}
+
+	for n, w := range weighted {
+		// The weight follows the last ":", and the target is
+		// either an upstream address or a cluster name.
+		i := strings.LastIndexByte(w, ':')
+		if i < 0 {
+			return nil, nil, fmt.Errorf("invalid weighted upstream %q", w)
+		}
+
+		weight, err := strconv.ParseUint(w[i+1:], 10, 32)
+		if err != nil || weight == 0 {
+			return nil, nil, fmt.Errorf("invalid weight in upstream %q", w)
+		}
+
+		name := w[:i]
+
+		if host, _, err := endpoints.ParseHostPort(name); err == nil && net.ParseIP(host) != nil {
+			eps, err := newUpstreamEndpoints(name)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			name = fmt.Sprintf("hack/tcpproxy/cluster/%s/%d", hostPort, n)
+			if assignments[name], err = endpoints.NewLoadAssignment(name, eps); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		clusters = append(clusters, &weightedCluster{Name: name, Weight: uint32(weight)})
+	}
 
-	osname := must.String(spec.Parameters["os"].AsString())
+	// An empty list passes the check above, but Envoy requires at
+	// least one cluster.
+	if len(clusters) == 0 {
+		return nil, nil, fmt.Errorf("the upstream or upstreams list must not be empty")
+	}
+
+	return clusters, assignments, nil
+}
+
+// HackTCPProxy builds a TCP proxy listener that forwards connections
+// to an existing cluster, or to generated EDS clusters for the given
+// upstream endpoints. Connections can be split across clusters by
+// weight. Socket options are set to suit the platform of the node.
+func HackTCPProxy(env Env, spec Spec) (xds.Snapshot, error) {
+	name := spec.Parameters["name"].Value
+	platform := bootstrap.NodePlatform(env.Node)
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	idleTimeout, err := spec.Parameters["idle_timeout"].AsDuration()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if idleTimeout < 0 {
+		return xds.Snapshot{}, fmt.Errorf("invalid idle_timeout %s (must not be negative)", idleTimeout)
+	}
+
+	maxConnectAttempts, err := spec.Parameters["max_connect_attempts"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
 
-	if cluster == "" {
-		cluster = fmt.Sprintf("tcpproxy/cluster/%s/%d", name, port)
+	if maxConnectAttempts < 1 {
+		return xds.Snapshot{}, fmt.Errorf("invalid max_connect_attempts %d (must be at least 1)", maxConnectAttempts)
+	}
+
+	keepalive, err := spec.Parameters["keepalive"].AsBool()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	var socketOptions []*bootstrap.SocketOption
+	if keepalive {
+		opt := platform.KeepaliveSocketOption()
+		if opt == nil {
+			return xds.Snapshot{}, fmt.Errorf("keepalive is not supported on platform %q", platform.OS)
+		}
+
+		socketOptions = append(socketOptions, opt)
+	}
+
+	hostPort := net.JoinHostPort(addr.String(), strconv.FormatInt(port, 10))
+
+	statPrefix := fmt.Sprintf("%s:%d", name, port)
+	if name == "" {
+		name = fmt.Sprintf("hack/tcpproxy/listener/%s", hostPort)
+		statPrefix = strings.Replace(name, "/", "-", -1)
+	}
+
+	clusters, assignments, err := newTCPUpstreams(spec, hostPort)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	proxy := &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy{
+		StatPrefix: statPrefix,
+		ClusterSpecifier: &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_WeightedClusters{
+			WeightedClusters: &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_WeightedCluster{
+				Clusters: clusters,
+			},
+		},
+		IdleTimeout:        ptypes.DurationProto(idleTimeout),
+		AccessLog:          nil,
+		MaxConnectAttempts: bootstrap.UInt32(uint32(maxConnectAttempts)),
+	}
+
+	if path := spec.Parameters["access_log"].Value; path != "" {
+		proxy.AccessLog = append(proxy.AccessLog, accesslog.NewFileAccessLog(path))
+	}
+
+	// A prefix range for the unspecified address would never
+	// match the destination, so only match specific addresses.
+	var prefixRanges []*bootstrap.CidrRange
+	if !addr.IsUnspecified() {
+		prefixRanges = append(prefixRanges, bootstrap.NewCidrForIP(addr))
 	}
 
 	anyAddr := bootstrap.NewSocketAddress(
@@ -36,9 +252,7 @@ func HackTCPProxy(spec Spec) xds.Snapshot {
 
 	chains := &bootstrap.FilterChain{
 		FilterChainMatch: &bootstrap.FilterChainMatch{
-			PrefixRanges: []*bootstrap.CidrRange{
-				bootstrap.NewCidrForIP(addr),
-			},
+			PrefixRanges:         prefixRanges,
 			SourceType:           0,
 			SourcePrefixRanges:   nil,
 			SourcePorts:          nil,
@@ -48,22 +262,7 @@ func HackTCPProxy(spec Spec) xds.Snapshot {
 		},
 		Filters: []*bootstrap.Filter{
 			bootstrap.NewFilter("envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
-				bootstrap.ProtoV2(&envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy{
-					StatPrefix: fmt.Sprintf("%s:%d", name, port),
-					ClusterSpecifier: &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_WeightedClusters{
-						WeightedClusters: &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_WeightedCluster{
-							Clusters: []*envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_WeightedCluster_ClusterWeight{
-								&envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_WeightedCluster_ClusterWeight{
-									Name:   cluster,
-									Weight: 100,
-								},
-							},
-						},
-					},
-					IdleTimeout:        ptypes.DurationProto(time.Hour), // Default.
-					AccessLog:          nil,
-					MaxConnectAttempts: bootstrap.UInt32(5),
-				}),
+				bootstrap.ProtoV2(proxy),
 			),
 		},
 		UseProxyProto: bootstrap.False(),
@@ -77,23 +276,16 @@ func HackTCPProxy(spec Spec) xds.Snapshot {
 		ListenerFiltersTimeout:           ptypes.DurationProto(time.Second * 15), // Default.
 		ContinueOnListenerFiltersTimeout: false,
 		Transparent:                      bootstrap.False(),
-		SocketOptions:                    nil,
+		Freebind:                         platform.Freebind(),
+		SocketOptions:                    socketOptions,
 		TrafficDirection:                 bootstrap.INBOUND,
-		ReusePort:                        true,
+		ReusePort:                        platform.ReusePort(),
 		AccessLog:                        nil,
 	}
 
-	// NOTE(jpeach) Setting this makes Envoy crash unless it
-	// is running on Linux. Need to filter it, depending on the
-	// node.
-	//
-	// https://github.com/envoyproxy/envoy/issues/11340
-	if strings.ToLower(osname) == "linux" {
-		listener.Freebind = bootstrap.True()
-	}
-
-	snap := xds.Snapshot{}
+	// Generated clusters are published along with their endpoints.
+	snap := endpoints.Snapshot(assignments)
 	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
 
-	return snap
+	return snap, nil
 }
@@ -0,0 +1,87 @@
+package hacks
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+)
+
+func TestHackTCPProxy(t *testing.T) {
+	for _, tc := range []struct {
+		spec      string
+		want      string
+		listeners []string
+		clusters  []string
+	}{
+		{
+			spec:      "tcpproxy:address=127.0.0.1,port=8080,upstream=127.0.0.1:9000",
+			listeners: []string{"hack/tcpproxy/listener/127.0.0.1:8080"},
+			clusters:  []string{"hack/tcpproxy/cluster/127.0.0.1:8080"},
+		},
+		{
+			spec:      "tcpproxy:address=::1,port=8080,upstreams=127.0.0.1:9000:70;backend/tcp:30",
+			listeners: []string{"hack/tcpproxy/listener/[::1]:8080"},
+			clusters:  []string{"hack/tcpproxy/cluster/[::1]:8080/0"},
+		},
+		{
+			spec:      "tcpproxy:address=127.0.0.1,port=8080,cluster=backend/tcp",
+			listeners: []string{"hack/tcpproxy/listener/127.0.0.1:8080"},
+		},
+		{
+			spec: "tcpproxy:address=127.0.0.1,port=8080,upstreams=[]",
+			want: "the upstream or upstreams list must not be empty",
+		},
+		{
+			spec: "tcpproxy:address=127.0.0.1,port=8080,upstream=[]",
+			want: "the upstream or upstreams list must not be empty",
+		},
+		{
+			spec: "tcpproxy:address=127.0.0.1,port=8080",
+			want: "exactly one of the cluster, upstream or upstreams parameters is required",
+		},
+	} {
+		spec, err := ParseSpec(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.spec, err)
+		}
+
+		h, _ := Lookup("tcpproxy")
+
+		snap, err := h.Build(Env{}, spec)
+		if tc.want != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("%s: got error %v, want %q", tc.spec, err, tc.want)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %s", tc.spec, err)
+		}
+
+		if names := resourceNames(snap, xds.ListenerType); !reflect.DeepEqual(names, tc.listeners) {
+			t.Fatalf("%s: got listeners %q, want %q", tc.spec, names, tc.listeners)
+		}
+
+		if names := resourceNames(snap, xds.ClusterType); !reflect.DeepEqual(names, tc.clusters) {
+			t.Fatalf("%s: got clusters %q, want %q", tc.spec, names, tc.clusters)
+		}
+	}
+}
+
+// resourceNames returns the sorted names of the snapshot resources
+// of the given type.
+func resourceNames(snap xds.Snapshot, t xds.ResponseType) []string {
+	var names []string
+	for name := range snap.Resources[t].Items {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
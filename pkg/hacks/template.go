@@ -0,0 +1,273 @@
+package hacks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	"github.com/ghodss/yaml"
+)
+
+// TemplateFile is a hack that is defined by a YAML file, e.g.:
+//
+//	name: echo
+//	description: HTTP listener that returns a fixed response
+//	parameters:
+//	- name: port
+//	  type: port
+//	  required: true
+//	  help: Listener port
+//	template: |
+//	  - "@type": type.googleapis.com/envoy.config.listener.v3.Listener
+//	    name: hack/echo/listener/{{ .Params.port }}
+//	    ...
+//
+// The template is a Go text/template that renders a YAML list of xDS
+// resources, each of which has an "@type" field with its type URL.
+type TemplateFile struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Parameters  []ParameterSchema `json:"parameters"`
+	Template    string            `json:"template"`
+}
+
+// TemplateData is the data that a hack template is executed with.
+type TemplateData struct {
+	// Params holds each hack parameter. Scalars are strings, lists
+	// are slices and maps are maps. Parameters that aren't given and
+	// have no default are empty strings.
+	Params map[string]interface{}
+	// Node is the Envoy node that the hack is built for.
+	Node *bootstrap.Node
+	// Platform is the platform of the node.
+	Platform bootstrap.Platform
+	// RunDir is the run directory of envoy-bootstrap.
+	RunDir string
+}
+
+// templateFuncs are the functions that hack templates can use, in
+// addition to the text/template builtins.
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON, which is also valid YAML.
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// replace replaces each old substring with new, e.g.
+	// {{ "a/b" | replace "/" "-" }}.
+	"replace": func(old string, new string, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+}
+
+// TemplateDir returns the directory that hack templates are loaded
+// from, which is "envoy-bootstrap/hacks" in $XDG_CONFIG_HOME, or in
+// "~/.config" if that isn't set.
+func TemplateDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "envoy-bootstrap", "hacks"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".config", "envoy-bootstrap", "hacks"), nil
+}
+
+// templateName returns the name of the hack that a template file
+// defines. If the file doesn't name the hack, or can't be read, the
+// hack is named after the file, without its extension.
+func templateName(path string, f *TemplateFile) string {
+	if f != nil && f.Name != "" {
+		return f.Name
+	}
+
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// ReadTemplateFile reads a hack template file. If the file doesn't
+// name the hack, it is named after the file, without its extension.
+func ReadTemplateFile(path string) (Hack, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f TemplateFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	f.Name = templateName(path, &f)
+
+	if f.Description == "" {
+		f.Description = fmt.Sprintf("Template hack (%s)", path)
+	}
+
+	for i, p := range f.Parameters {
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("%s: parameter %d: missing name", path, i)
+		case p.Type == "":
+			f.Parameters[i].Type = StringParameter
+		case !p.Type.Known():
+			return nil, fmt.Errorf("%s: parameter %q: unknown type %q", path, p.Name, p.Type)
+		}
+
+		if p.Default != "" {
+			if err := f.Parameters[i].Type.Check(NewParameter(p.Default)); err != nil {
+				return nil, fmt.Errorf("%s: parameter %q: default: %w", path, p.Name, err)
+			}
+		}
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		Parse(f.Template)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return New(f.Name, f.Description, f.Parameters, func(env Env, spec Spec) (xds.Snapshot, error) {
+		return buildTemplate(tmpl, f.Parameters, env, spec)
+	}), nil
+}
+
+// buildTemplate executes the template and decodes the resources that
+// it renders.
+func buildTemplate(tmpl *template.Template, schema []ParameterSchema, env Env, spec Spec) (xds.Snapshot, error) {
+	data := TemplateData{
+		Params:   map[string]interface{}{},
+		Node:     env.Node,
+		Platform: bootstrap.NodePlatform(env.Node),
+		RunDir:   env.RunDir,
+	}
+
+	types := map[string]ParameterType{}
+
+	for _, p := range schema {
+		types[p.Name] = p.Type
+		data.Params[p.Name] = ""
+	}
+
+	for name, p := range spec.Parameters {
+		// Make sure that a list is always a slice, even when
+		// it is given as a single scalar.
+		if types[name] == ListParameter && !p.IsList() {
+			list, err := p.AsList()
+			if err != nil {
+				return xds.Snapshot{}, err
+			}
+
+			p = Parameter{List: list}
+		}
+
+		encoded, err := json.Marshal(p)
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(encoded, &v); err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		data.Params[name] = v
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, &data); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	encoded, err := yaml.YAMLToJSON(out.Bytes())
+	if err != nil {
+		return xds.Snapshot{}, fmt.Errorf("%s: invalid YAML output: %w", tmpl.Name(), err)
+	}
+
+	var resources []json.RawMessage
+	if err := json.Unmarshal(encoded, &resources); err != nil {
+		return xds.Snapshot{}, fmt.Errorf("%s: output must be a list of resources: %w", tmpl.Name(), err)
+	}
+
+	return newResourceSnapshot(resources)
+}
+
+// invalidTemplate is a hack for a template file that failed to load.
+// Building it returns the load error, so that only the specs that use
+// the hack fail.
+type invalidTemplate struct {
+	name string
+	path string
+	err  error
+}
+
+var _ Hack = &invalidTemplate{}
+
+func (t *invalidTemplate) Name() string                  { return t.name }
+func (t *invalidTemplate) Description() string           { return fmt.Sprintf("Bad template hack (%s)", t.path) }
+func (t *invalidTemplate) Parameters() []ParameterSchema { return nil }
+
+func (t *invalidTemplate) Build(Env, Spec) (xds.Snapshot, error) {
+	return xds.Snapshot{}, t.err
+}
+
+// RegisterTemplates registers a hack for each ".yaml" or ".yml"
+// template file in the directory. It is not an error for the
+// directory not to exist. A file that fails to load is logged and
+// registered as a hack that fails to build, so that it doesn't break
+// the specs that don't use it.
+func RegisterTemplates(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".yaml", ".yml":
+		default:
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+
+		h, err := ReadTemplateFile(path)
+		if err != nil {
+			log.Printf("invalid hack template: %s", err)
+
+			// Name the hack as well as the file allows.
+			var f TemplateFile
+			if data, err := ioutil.ReadFile(path); err == nil {
+				_ = yaml.Unmarshal(data, &f)
+			}
+
+			h = &invalidTemplate{name: templateName(path, &f), path: path, err: err}
+		}
+
+		if _, ok := registry[h.Name()]; ok {
+			log.Printf("invalid hack template: %s: hack %q is already registered", path, h.Name())
+			continue
+		}
+
+		Register(h)
+	}
+
+	return nil
+}
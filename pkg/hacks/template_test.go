@@ -0,0 +1,163 @@
+package hacks
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+)
+
+func TestBuildTemplate(t *testing.T) {
+	schema := []ParameterSchema{
+		{Name: "name", Type: StringParameter},
+		{Name: "names", Type: ListParameter},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		template string
+		params   map[string]Parameter
+		want     string
+		clusters int
+	}{
+		{
+			name: "valid",
+			template: `
+{{- range .Params.names }}
+- "@type": type.googleapis.com/envoy.config.cluster.v3.Cluster
+  name: {{ . }}
+  connectTimeout: 1s
+{{- end }}
+`,
+			params: map[string]Parameter{
+				"names": {List: []Parameter{NewParameter("a"), NewParameter("b")}},
+			},
+			clusters: 2,
+		},
+		{
+			name: "scalar list parameter",
+			template: `
+{{- range .Params.names }}
+- "@type": type.googleapis.com/envoy.config.cluster.v3.Cluster
+  name: {{ . }}
+  connectTimeout: 1s
+{{- end }}
+`,
+			params:   map[string]Parameter{"names": NewParameter("a")},
+			clusters: 1,
+		},
+		{
+			name:     "empty",
+			template: `[]`,
+		},
+		{
+			name: "not a list",
+			template: `
+"@type": type.googleapis.com/envoy.config.cluster.v3.Cluster
+name: {{ .Params.name }}
+`,
+			params: map[string]Parameter{"name": NewParameter("a")},
+			want:   "output must be a list of resources",
+		},
+		{
+			name:     "invalid YAML",
+			template: `- {{ .Params.name }}: [`,
+			params:   map[string]Parameter{"name": NewParameter("a")},
+			want:     "invalid YAML output",
+		},
+		{
+			name:     "missing key",
+			template: `{{ .Params.missing }}`,
+			want:     `map has no entry for key "missing"`,
+		},
+		{
+			name: "invalid resource",
+			template: `
+- "@type": type.googleapis.com/envoy.config.cluster.v3.Cluster
+  connectTimeout: 1s
+`,
+			want: "resource 0: invalid Cluster.Name",
+		},
+	} {
+		tmpl, err := template.New(tc.name).
+			Funcs(templateFuncs).
+			Option("missingkey=error").
+			Parse(tc.template)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		snap, err := buildTemplate(tmpl, schema, Env{}, Spec{Hack: "test", Parameters: tc.params})
+		if tc.want != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("%s: got error %v, want %q", tc.name, err, tc.want)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		if n := len(snap.Resources[xds.ClusterType].Items); n != tc.clusters {
+			t.Fatalf("%s: got %d clusters, want %d", tc.name, n, tc.clusters)
+		}
+	}
+}
+
+func TestRegisterTemplates(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, data := range map[string]string{
+		"test-template-good.yaml": `
+template: |
+  - "@type": type.googleapis.com/envoy.config.cluster.v3.Cluster
+    name: good
+    connectTimeout: 1s
+`,
+		"test-template-bad.yaml": `
+parameters:
+- name: port
+  type: nonsense
+template: "[]"
+`,
+		"test-template-unparsable.yaml": `template: [`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A bad template file must not stop the others from loading.
+	if err := RegisterTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	h, ok := Lookup("test-template-good")
+	if !ok {
+		t.Fatalf("test-template-good is not registered")
+	}
+
+	if _, err := h.Build(Env{}, Spec{Hack: h.Name()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bad templates are registered, but fail to build.
+	for name, want := range map[string]string{
+		"test-template-bad":        `unknown type "nonsense"`,
+		"test-template-unparsable": "test-template-unparsable.yaml",
+	} {
+		h, ok := Lookup(name)
+		if !ok {
+			t.Fatalf("%s is not registered", name)
+		}
+
+		if _, err := h.Build(Env{}, Spec{Hack: name}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: got error %v, want %q", name, err, want)
+		}
+	}
+}
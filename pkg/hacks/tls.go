@@ -0,0 +1,251 @@
+package hacks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/certs"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	"github.com/golang/protobuf/ptypes"
+)
+
+func init() {
+	Register(New("tls",
+		"HTTPS listener that terminates TLS with a filter chain for each SNI name",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "sni", Type: ListParameter, Default: "localhost", Help: "Server names to match, each with its own filter chain"},
+			{Name: "cert", Type: StringParameter, Help: "PEM certificate chain, usually given as @path (generated if not set)"},
+			{Name: "key", Type: StringParameter, Help: "PEM private key, usually given as @path"},
+			{Name: "client_ca", Type: StringParameter, Help: "PEM CA certificate that validates client certificates"},
+			{Name: "require_client_cert", Type: BoolParameter, Default: "false",
+				Help: "Require client certificates (issued by the generated CA unless client_ca is set)"},
+			{Name: "upstream", Type: AddressParameter, Help: "Upstream address (requests get a direct response if not set)"},
+		},
+		HackTLS,
+	))
+}
+
+// writeFiles writes the named files to the given directory.
+func writeFiles(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readPair reads a PEM certificate and key from the named files in the
+// directory. If either file doesn't exist, it returns nil.
+func readPair(dir string, certName string, keyName string) (*certs.Pair, error) {
+	certPEM, err := ioutil.ReadFile(path.Join(dir, certName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(path.Join(dir, keyName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &certs.Pair{CertPEM: certPEM, KeyPEM: keyPEM}, nil
+}
+
+// HackTLS builds an HTTPS listener that terminates TLS. Each SNI
+// name gets a filter chain with its own certificate, which is either
+// issued by an in-memory CA or loaded from the "cert" and "key"
+// parameters. Generated CA and client certificates are written to
+// the run directory so that clients can use them. If the hack is
+// built again, e.g. for another node, it reuses them.
+func HackTLS(env Env, spec Spec) (xds.Snapshot, error) {
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	sniList, err := spec.Parameters["sni"].AsList()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	requireClientCert, err := spec.Parameters["require_client_cert"].AsBool()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	certPEM := []byte(spec.Parameters["cert"].Value)
+	keyPEM := []byte(spec.Parameters["key"].Value)
+	clientCAPEM := []byte(spec.Parameters["client_ca"].Value)
+
+	if (len(certPEM) == 0) != (len(keyPEM) == 0) {
+		return xds.Snapshot{}, fmt.Errorf("the cert and key parameters must be used together")
+	}
+
+	var serverNames []string
+	for _, s := range sniList {
+		if s.Value == "" {
+			return xds.Snapshot{}, fmt.Errorf("empty SNI name")
+		}
+
+		serverNames = append(serverNames, s.Value)
+	}
+
+	if len(serverNames) == 0 {
+		return xds.Snapshot{}, fmt.Errorf("at least one SNI name is required")
+	}
+
+	tlsDir := path.Join(env.RunDir, "tls", fmt.Sprint(port))
+	files := map[string][]byte{}
+
+	var ca *certs.CA
+	var reuseCA bool
+
+	generateServerCerts := len(certPEM) == 0
+	generateClientCert := requireClientCert && len(clientCAPEM) == 0
+
+	if generateServerCerts || generateClientCert {
+		pair, err := readPair(tlsDir, "ca.pem", "ca-key.pem")
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		if reuseCA = pair != nil; reuseCA {
+			if ca, err = certs.ParseCA(pair.CertPEM, pair.KeyPEM); err != nil {
+				return xds.Snapshot{}, fmt.Errorf("%s: %w", tlsDir, err)
+			}
+		} else {
+			if ca, err = certs.NewCA(fmt.Sprintf("envoy-bootstrap CA %d", port)); err != nil {
+				return xds.Snapshot{}, err
+			}
+
+			files["ca.pem"] = ca.CertPEM
+			files["ca-key.pem"] = ca.KeyPEM
+		}
+	}
+
+	if generateClientCert {
+		// A client certificate is only reused along with the
+		// CA that issued it.
+		var client *certs.Pair
+		if reuseCA {
+			if client, err = readPair(tlsDir, "client.pem", "client-key.pem"); err != nil {
+				return xds.Snapshot{}, err
+			}
+		}
+
+		if client == nil {
+			if client, err = ca.IssueClient("envoy-bootstrap client"); err != nil {
+				return xds.Snapshot{}, err
+			}
+
+			files["client.pem"] = client.CertPEM
+			files["client-key.pem"] = client.KeyPEM
+		}
+
+		clientCAPEM = ca.CertPEM
+	}
+
+	listenerName := fmt.Sprintf("hack/tls/listener/%d", port)
+	routeName := fmt.Sprintf("hack/tls/route/%d", port)
+	upstreamName := fmt.Sprintf("hack/tls/cluster/%d", port)
+
+	var filterChains []*bootstrap.FilterChain
+
+	for _, name := range serverNames {
+		cert := bootstrap.NewTLSCertificate(certPEM, keyPEM)
+		if generateServerCerts {
+			pair, err := ca.IssueServer(name)
+			if err != nil {
+				return xds.Snapshot{}, err
+			}
+
+			cert = bootstrap.NewTLSCertificate(pair.CertPEM, pair.KeyPEM)
+		}
+
+		filterChains = append(filterChains, &bootstrap.FilterChain{
+			FilterChainMatch: &bootstrap.FilterChainMatch{
+				ServerNames: []string{name},
+			},
+			Filters: []*bootstrap.Filter{
+				NewHTTPConnectionManager(fmt.Sprintf("hack-tls-%d-%s", port, strings.Replace(name, ".", "-", -1)), routeName),
+			},
+			TransportSocket: bootstrap.NewDownstreamTLSTransportSocket(
+				bootstrap.NewDownstreamTLSContext(cert, clientCAPEM, requireClientCert)),
+		})
+	}
+
+	listener := &bootstrap.Listener{
+		Name: listenerName,
+		Address: bootstrap.NewSocketAddress(
+			&bootstrap.SocketAddress{
+				Protocol:      bootstrap.TCP,
+				Address:       addr.String(),
+				PortSpecifier: bootstrap.NewPortValue(uint32(port)),
+			}),
+		FilterChains: filterChains,
+		ListenerFilters: []*bootstrap.ListenerFilter{
+			&bootstrap.ListenerFilter{
+				Name: "envoy.filters.listener.tls_inspector",
+			},
+		},
+		ListenerFiltersTimeout: ptypes.DurationProto(time.Second * 15), // Default.
+		TrafficDirection:       bootstrap.INBOUND,
+	}
+
+	route, clusters, err := NewUpstreamRoute(spec, upstreamName)
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if len(files) > 0 {
+		if err := writeFiles(tlsDir, files); err != nil {
+			return xds.Snapshot{}, err
+		}
+	}
+
+	if generateServerCerts {
+		resolve := addr.String()
+		if addr.To4() == nil {
+			resolve = "[" + resolve + "]"
+		}
+
+		log.Printf("tls: listener %q certificates are issued by CA %s, e.g. curl --cacert %s --resolve %s:%d:%s https://%s:%d/",
+			listenerName, path.Join(tlsDir, "ca.pem"), path.Join(tlsDir, "ca.pem"),
+			serverNames[0], port, resolve, serverNames[0], port)
+	}
+
+	if generateClientCert {
+		log.Printf("tls: listener %q requires client certificates, e.g. curl --cert %s --key %s",
+			listenerName, path.Join(tlsDir, "client.pem"), path.Join(tlsDir, "client-key.pem"))
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+	snap.Resources[xds.RouteType] = xds.NewResources(NewVersion(), NewRouteConfiguration(routeName, route))
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+
+	return snap, nil
+}
@@ -0,0 +1,190 @@
+package hacks
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_extensions_filters_listener_original_dst_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/original_dst/v3"
+	envoy_extensions_filters_listener_original_src_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/original_src/v3"
+	envoy_extensions_filters_network_tcp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// capNetAdmin is the CAP_NET_ADMIN capability number from
+// <linux/capability.h>.
+const capNetAdmin = 12
+
+func init() {
+	Register(New("transparent",
+		"Transparent TCP proxy listener that forwards intercepted connections to their original destination (Linux, needs CAP_NET_ADMIN)",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Default: "0.0.0.0", Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port that connections are redirected to"},
+			{Name: "original_src", Type: BoolParameter, Default: "false",
+				Help: "Connect upstream from the downstream source address (needs policy routing for the replies)"},
+			{Name: "mark", Type: IntParameter, Default: "0", Help: "Socket mark of upstream connections when original_src is set"},
+			{Name: "idle_timeout", Type: DurationParameter, Default: "1h", Help: "Idle connection timeout"},
+		},
+		HackTransparent,
+	))
+}
+
+// hasCapability returns whether the current process has the given
+// permitted capability. File capabilities aren't inherited across
+// execve, so the run command passes CAP_NET_ADMIN on to Envoy as an
+// ambient capability if it is permitted. This only works on Linux,
+// since we read the capabilities from /proc.
+func hasCapability(capability uint) (bool, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), "CapPrm:") {
+			continue
+		}
+
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "CapPrm:")), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid permitted capabilities %q", scanner.Text())
+		}
+
+		return caps&(1<<capability) != 0, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	return false, fmt.Errorf("no permitted capabilities in /proc/self/status")
+}
+
+// netAdmin caches the result of checkNetAdmin, since the capabilities
+// of the process don't change while it runs.
+var netAdmin struct {
+	once sync.Once
+	err  error
+}
+
+// checkNetAdmin returns an error if Envoy won't have CAP_NET_ADMIN.
+func checkNetAdmin() error {
+	netAdmin.once.Do(func() {
+		ok, err := hasCapability(capNetAdmin)
+		switch {
+		case os.IsNotExist(err):
+			netAdmin.err = fmt.Errorf("transparent proxying is only supported on Linux")
+		case err != nil:
+			netAdmin.err = fmt.Errorf("failed to check for CAP_NET_ADMIN: %w", err)
+		case !ok:
+			netAdmin.err = fmt.Errorf("transparent proxying needs CAP_NET_ADMIN, " +
+				"e.g. run as root or use setcap cap_net_admin+p on envoy-bootstrap")
+		}
+	})
+
+	return netAdmin.err
+}
+
+// HackTransparent builds a transparent TCP proxy listener. Connections
+// that are redirected to the listener, e.g. by an iptables REDIRECT or
+// TPROXY rule, are forwarded to their original destination through an
+// ORIGINAL_DST cluster. For example:
+//
+//	iptables -t nat -A OUTPUT -p tcp --dport 80 -m owner ! --uid-owner envoy -j REDIRECT --to-ports 15001
+//
+// The listener is transparent, which needs CAP_NET_ADMIN, so the hack
+// fails unless envoy-bootstrap has it to pass on to Envoy. With
+// "original_src", upstream connections also use the downstream source
+// address, and the replies must be routed back to Envoy by matching
+// the socket mark.
+func HackTransparent(_ Env, spec Spec) (xds.Snapshot, error) {
+	type OriginalDst = envoy_extensions_filters_listener_original_dst_v3.OriginalDst
+	type OriginalSrc = envoy_extensions_filters_listener_original_src_v3.OriginalSrc
+	type TcpProxy = envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	originalSrc, err := spec.Parameters["original_src"].AsBool()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	mark, err := spec.Parameters["mark"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if mark < 0 {
+		return xds.Snapshot{}, fmt.Errorf("parameter %q must not be negative", "mark")
+	}
+
+	if mark != 0 && !originalSrc {
+		return xds.Snapshot{}, fmt.Errorf("the mark parameter needs original_src")
+	}
+
+	idleTimeout, err := spec.Parameters["idle_timeout"].AsDuration()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if idleTimeout < 0 {
+		return xds.Snapshot{}, fmt.Errorf("parameter %q must not be negative", "idle_timeout")
+	}
+
+	if err := checkNetAdmin(); err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	listenerName := fmt.Sprintf("hack/transparent/listener/%d", port)
+	clusterName := fmt.Sprintf("hack/transparent/cluster/%d", port)
+
+	listenerFilters := []*bootstrap.ListenerFilter{
+		bootstrap.NewListenerFilter("envoy.filters.listener.original_dst",
+			bootstrap.ProtoV2(&OriginalDst{})),
+	}
+
+	if originalSrc {
+		listenerFilters = append(listenerFilters,
+			bootstrap.NewListenerFilter("envoy.filters.listener.original_src",
+				bootstrap.ProtoV2(&OriginalSrc{Mark: uint32(mark)})))
+	}
+
+	proxy := &TcpProxy{
+		StatPrefix: strings.Replace(listenerName, "/", "-", -1),
+		ClusterSpecifier: &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy_Cluster{
+			Cluster: clusterName,
+		},
+		IdleTimeout: ptypes.DurationProto(idleTimeout),
+	}
+
+	listener := NewTCPListener(listenerName, addr, port,
+		bootstrap.NewFilter("envoy.filters.network.tcp_proxy", bootstrap.ProtoV2(proxy)))
+	listener.ListenerFilters = listenerFilters
+	listener.ListenerFiltersTimeout = ptypes.DurationProto(time.Second * 15) // Default.
+	listener.Transparent = bootstrap.True()
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), bootstrap.NewOriginalDstCluster(clusterName))
+
+	return snap, nil
+}
@@ -0,0 +1,106 @@
+package hacks
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+	"github.com/jpeach/envoy-bootstrap/pkg/xds"
+
+	envoy_extensions_filters_udp_udp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+)
+
+func init() {
+	Register(New("udpproxy",
+		"UDP listener that proxies datagrams to a cluster",
+		[]ParameterSchema{
+			{Name: "address", Type: IPParameter, Required: true, Help: "Listener IP address"},
+			{Name: "port", Type: PortParameter, Required: true, Help: "Listener port"},
+			{Name: "cluster", Type: StringParameter, Help: "Cluster to proxy to, e.g. backend/udp"},
+			{Name: "upstream", Type: AddressParameter, Help: "Upstream address to proxy to"},
+			{Name: "idle_timeout", Type: DurationParameter, Default: "1m",
+				Help: "Idle timeout of the session for each downstream address"},
+		},
+		HackUDPProxy,
+	))
+}
+
+// HackUDPProxy builds a UDP listener with the UDP proxy filter. Each
+// downstream address gets a session with an upstream host of the named
+// cluster, or of a cluster for the upstream address. Run it with a
+// "udp" backend to test request/response protocols like DNS.
+func HackUDPProxy(_ Env, spec Spec) (xds.Snapshot, error) {
+	type UdpProxyConfig = envoy_extensions_filters_udp_udp_proxy_v3.UdpProxyConfig
+
+	addr, err := spec.Parameters["address"].IP()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	port, err := spec.Parameters["port"].AsInt64()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	idleTimeout, err := spec.Parameters["idle_timeout"].AsDuration()
+	if err != nil {
+		return xds.Snapshot{}, err
+	}
+
+	if idleTimeout <= 0 {
+		return xds.Snapshot{}, fmt.Errorf("parameter %q must be positive", "idle_timeout")
+	}
+
+	listenerName := fmt.Sprintf("hack/udpproxy/listener/%d", port)
+	clusterName := spec.Parameters["cluster"].Value
+
+	var clusters []protov1.Message
+
+	switch {
+	case !spec.Parameters["upstream"].IsZero() && clusterName != "":
+		return xds.Snapshot{}, fmt.Errorf("the cluster and upstream parameters cannot be used together")
+	case !spec.Parameters["upstream"].IsZero():
+		upstream, err := spec.Parameters["upstream"].TCPAddr()
+		if err != nil {
+			return xds.Snapshot{}, err
+		}
+
+		clusterName = fmt.Sprintf("hack/udpproxy/cluster/%d", port)
+		clusters = append(clusters, bootstrap.NewStaticCluster(clusterName,
+			bootstrap.NewUDPHostAddress(&net.UDPAddr{IP: upstream.IP, Port: upstream.Port})))
+	case clusterName == "":
+		return xds.Snapshot{}, fmt.Errorf("one of the cluster or upstream parameters is required")
+	}
+
+	listener := &bootstrap.Listener{
+		Name: listenerName,
+		Address: bootstrap.NewSocketAddress(
+			&bootstrap.SocketAddress{
+				Protocol:      bootstrap.UDP,
+				Address:       addr.String(),
+				PortSpecifier: bootstrap.NewPortValue(uint32(port)),
+			}),
+		ListenerFilters: []*bootstrap.ListenerFilter{
+			bootstrap.NewListenerFilter(
+				"envoy.filters.udp_listener.udp_proxy",
+				bootstrap.ProtoV2(&UdpProxyConfig{
+					StatPrefix: strings.Replace(listenerName, "/", "-", -1),
+					RouteSpecifier: &envoy_extensions_filters_udp_udp_proxy_v3.UdpProxyConfig_Cluster{
+						Cluster: clusterName,
+					},
+					IdleTimeout: ptypes.DurationProto(idleTimeout),
+				}),
+			),
+		},
+		TrafficDirection: bootstrap.INBOUND,
+	}
+
+	snap := xds.Snapshot{}
+	snap.Resources[xds.ListenerType] = xds.NewResources(NewVersion(), listener)
+	snap.Resources[xds.ClusterType] = xds.NewResources(NewVersion(), clusters...)
+
+	return snap, nil
+}
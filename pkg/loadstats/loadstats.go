@@ -0,0 +1,214 @@
+package loadstats
+
+import (
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_service_load_stats_v3 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v3"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
+)
+
+// DefaultInterval is the default interval at which Envoy is asked to
+// send load reports.
+const DefaultInterval = time.Second * 10
+
+// LocalityStats is the aggregated load for a cluster locality.
+type LocalityStats struct {
+	Cluster     string `json:"cluster"`
+	ServiceName string `json:"serviceName,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Zone        string `json:"zone,omitempty"`
+	SubZone     string `json:"subZone,omitempty"`
+	Priority    uint32 `json:"priority"`
+
+	SuccessfulRequests uint64 `json:"successfulRequests"`
+	ErrorRequests      uint64 `json:"errorRequests"`
+	IssuedRequests     uint64 `json:"issuedRequests"`
+
+	// RequestsInProgress is a gauge, so it is the value from the
+	// most recent report.
+	RequestsInProgress uint64 `json:"requestsInProgress"`
+}
+
+// ClusterStats is the aggregated load for a cluster.
+type ClusterStats struct {
+	Cluster         string          `json:"cluster"`
+	ServiceName     string          `json:"serviceName,omitempty"`
+	DroppedRequests uint64          `json:"droppedRequests"`
+	Reports         uint64          `json:"reports"`
+	LastReport      time.Time       `json:"lastReport"`
+	Localities      []LocalityStats `json:"localities"`
+}
+
+type clusterKey struct {
+	cluster string
+	service string
+}
+
+type localityKey struct {
+	region   string
+	zone     string
+	subZone  string
+	priority uint32
+}
+
+type clusterLoad struct {
+	stats      ClusterStats
+	localities map[localityKey]*LocalityStats
+}
+
+// Server is a Load Reporting Service (LRS) server that aggregates the
+// load reports that Envoy sends for each cluster and locality.
+type Server struct {
+	// Interval is the load reporting interval requested from Envoy.
+	Interval time.Duration
+
+	lock     sync.Mutex
+	clusters map[clusterKey]*clusterLoad
+}
+
+var _ envoy_service_load_stats_v3.LoadReportingServiceServer = &Server{}
+
+// NewServer returns a new LRS Server.
+func NewServer(interval time.Duration) *Server {
+	return &Server{
+		Interval: interval,
+		clusters: map[clusterKey]*clusterLoad{},
+	}
+}
+
+// Register registers the LRS service on the gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	envoy_service_load_stats_v3.RegisterLoadReportingServiceServer(g, s)
+}
+
+// StreamLoadStats implements LoadReportingServiceServer.
+func (s *Server) StreamLoadStats(stream envoy_service_load_stats_v3.LoadReportingService_StreamLoadStatsServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// The first message on the stream identifies the node,
+		// and we respond by asking for reports on all clusters.
+		if node := req.GetNode(); node != nil {
+			log.Printf("load reporting stream opened by node %q", node.GetId())
+
+			if err := stream.Send(&envoy_service_load_stats_v3.LoadStatsResponse{
+				SendAllClusters:       true,
+				LoadReportingInterval: ptypes.DurationProto(s.Interval),
+			}); err != nil {
+				return err
+			}
+		}
+
+		s.record(req.GetClusterStats())
+	}
+}
+
+// record aggregates a set of cluster load reports.
+func (s *Server) record(reports []*envoy_config_endpoint_v3.ClusterStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+
+	for _, r := range reports {
+		key := clusterKey{cluster: r.GetClusterName(), service: r.GetClusterServiceName()}
+
+		load, ok := s.clusters[key]
+		if !ok {
+			load = &clusterLoad{
+				stats: ClusterStats{
+					Cluster:     key.cluster,
+					ServiceName: key.service,
+				},
+				localities: map[localityKey]*LocalityStats{},
+			}
+
+			s.clusters[key] = load
+		}
+
+		load.stats.Reports++
+		load.stats.LastReport = now
+		load.stats.DroppedRequests += r.GetTotalDroppedRequests()
+
+		for _, l := range r.GetUpstreamLocalityStats() {
+			lkey := localityKey{
+				region:   l.GetLocality().GetRegion(),
+				zone:     l.GetLocality().GetZone(),
+				subZone:  l.GetLocality().GetSubZone(),
+				priority: l.GetPriority(),
+			}
+
+			stats, ok := load.localities[lkey]
+			if !ok {
+				stats = &LocalityStats{
+					Cluster:     key.cluster,
+					ServiceName: key.service,
+					Region:      lkey.region,
+					Zone:        lkey.zone,
+					SubZone:     lkey.subZone,
+					Priority:    lkey.priority,
+				}
+
+				load.localities[lkey] = stats
+			}
+
+			// Envoy reports the request counts since the
+			// previous report, so we accumulate them.
+			stats.SuccessfulRequests += l.GetTotalSuccessfulRequests()
+			stats.ErrorRequests += l.GetTotalErrorRequests()
+			stats.IssuedRequests += l.GetTotalIssuedRequests()
+			stats.RequestsInProgress = l.GetTotalRequestsInProgress()
+		}
+	}
+}
+
+// Stats returns the aggregated load for each cluster, sorted by
+// cluster name.
+func (s *Server) Stats() []ClusterStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var result []ClusterStats
+
+	for _, load := range s.clusters {
+		stats := load.stats
+		stats.Localities = []LocalityStats{}
+
+		for _, l := range load.localities {
+			stats.Localities = append(stats.Localities, *l)
+		}
+
+		sort.Slice(stats.Localities, func(i, j int) bool {
+			a, b := stats.Localities[i], stats.Localities[j]
+			if a.Priority != b.Priority {
+				return a.Priority < b.Priority
+			}
+
+			return a.Region+"/"+a.Zone+"/"+a.SubZone < b.Region+"/"+b.Zone+"/"+b.SubZone
+		})
+
+		result = append(result, stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Cluster != result[j].Cluster {
+			return result[i].Cluster < result[j].Cluster
+		}
+
+		return result[i].ServiceName < result[j].ServiceName
+	})
+
+	return result
+}
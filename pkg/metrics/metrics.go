@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jpeach/envoy-bootstrap/pkg/bootstrap"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_metrics_v3 "github.com/envoyproxy/go-control-plane/envoy/config/metrics/v3"
+	envoy_service_metrics_v3 "github.com/envoyproxy/go-control-plane/envoy/service/metrics/v3"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+)
+
+// DefaultFlushInterval is the default interval at which Envoy flushes
+// stats to the metrics service.
+const DefaultFlushInterval = time.Second * 5
+
+// Stat is the latest value of an Envoy counter or gauge.
+type Stat struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Value   float64   `json:"value"`
+	Updated time.Time `json:"updated"`
+}
+
+// Server is a gRPC metrics service that keeps the latest value of
+// each counter and gauge that Envoy streams to it.
+type Server struct {
+	lock  sync.Mutex
+	stats map[string]Stat
+}
+
+var _ envoy_service_metrics_v3.MetricsServiceServer = &Server{}
+
+// NewServer returns a new metrics Server.
+func NewServer() *Server {
+	return &Server{
+		stats: map[string]Stat{},
+	}
+}
+
+// Register registers the metrics service on the gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	envoy_service_metrics_v3.RegisterMetricsServiceServer(g, s)
+}
+
+// StreamMetrics implements MetricsServiceServer.
+func (s *Server) StreamMetrics(stream envoy_service_metrics_v3.MetricsService_StreamMetricsServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&envoy_service_metrics_v3.StreamMetricsResponse{})
+		}
+		if err != nil {
+			return err
+		}
+
+		if id := msg.GetIdentifier(); id != nil {
+			log.Printf("metrics stream opened by node %q", id.GetNode().GetId())
+		}
+
+		s.record(msg.GetEnvoyMetrics())
+	}
+}
+
+func (s *Server) record(families []*io_prometheus_client.MetricFamily) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			stat := Stat{
+				Name:    f.GetName(),
+				Type:    "",
+				Updated: now,
+			}
+
+			switch f.GetType() {
+			case io_prometheus_client.MetricType_COUNTER:
+				stat.Type = "counter"
+				stat.Value = m.GetCounter().GetValue()
+			case io_prometheus_client.MetricType_GAUGE:
+				stat.Type = "gauge"
+				stat.Value = m.GetGauge().GetValue()
+			default:
+				// We only keep counters and gauges.
+				continue
+			}
+
+			s.stats[stat.Name] = stat
+		}
+	}
+}
+
+// Stats returns the latest stats whose names match the filter,
+// sorted by name. A nil filter matches all stats.
+func (s *Server) Stats(filter *regexp.Regexp) []Stat {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := []Stat{}
+
+	for name, stat := range s.stats {
+		if filter == nil || filter.MatchString(name) {
+			result = append(result, stat)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// NewStatsSink returns a metrics service stats sink that streams to
+// the named gRPC cluster.
+func NewStatsSink(clusterName string) *bootstrap.StatsSink {
+	config := &envoy_config_metrics_v3.MetricsServiceConfig{
+		GrpcService:         bootstrap.NewGrpcService(clusterName),
+		TransportApiVersion: envoy_config_core_v3.ApiVersion_V3,
+	}
+
+	any, err := bootstrap.MarshalAny(bootstrap.ProtoV2(config))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return &bootstrap.StatsSink{
+		Name: "envoy.stat_sinks.metrics_service",
+		ConfigType: &envoy_config_metrics_v3.StatsSink_TypedConfig{
+			TypedConfig: any,
+		},
+	}
+}
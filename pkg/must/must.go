@@ -1,6 +1,9 @@
 package must
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 // StringSlice ...
 func StringSlice(s []string, err error) []string {
@@ -29,6 +32,15 @@ func Int64(i int64, err error) int64 {
 	return i
 }
 
+// Float64 ...
+func Float64(f float64, err error) float64 {
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return f
+}
+
 // Bytes ...
 func Bytes(b []byte, err error) []byte {
 	if err != nil {
@@ -46,3 +58,30 @@ func IP(ip net.IP, err error) net.IP {
 
 	return ip
 }
+
+// Duration ...
+func Duration(d time.Duration, err error) time.Duration {
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return d
+}
+
+// Bool ...
+func Bool(b bool, err error) bool {
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return b
+}
+
+// TCPAddr ...
+func TCPAddr(addr *net.TCPAddr, err error) *net.TCPAddr {
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return addr
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Limit is a number of requests allowed per time unit.
+type Limit struct {
+	Unit            string `json:"unit"`
+	RequestsPerUnit uint32 `json:"requests_per_unit"`
+}
+
+// Descriptor matches a rate limit descriptor entry. A descriptor
+// with an empty value matches any value for its key. Nested
+// descriptors match the subsequent entries of the same descriptor.
+type Descriptor struct {
+	Key         string       `json:"key"`
+	Value       string       `json:"value,omitempty"`
+	RateLimit   *Limit       `json:"rate_limit,omitempty"`
+	Descriptors []Descriptor `json:"descriptors,omitempty"`
+}
+
+// Config is the set of rate limits for a domain. The format follows
+// the Lyft rate limit service configuration. For example:
+//
+//	domain: envoy-bootstrap
+//	descriptors:
+//	- key: remote_address
+//	  rate_limit:
+//	    unit: second
+//	    requests_per_unit: 5
+//	- key: path
+//	  value: /slow
+//	  rate_limit:
+//	    unit: minute
+//	    requests_per_unit: 10
+type Config struct {
+	Domain      string       `json:"domain"`
+	Descriptors []Descriptor `json:"descriptors"`
+}
+
+var units = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    time.Hour * 24,
+}
+
+// ReadConfig reads a YAML rate limit configuration file.
+func ReadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if c.Domain == "" {
+		return nil, fmt.Errorf("%s: missing rate limit domain", path)
+	}
+
+	if err := validateDescriptors(c.Descriptors); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &c, nil
+}
+
+func validateDescriptors(descriptors []Descriptor) error {
+	for _, d := range descriptors {
+		if d.Key == "" {
+			return fmt.Errorf("descriptor with empty key")
+		}
+
+		if d.RateLimit != nil {
+			if _, ok := units[strings.ToLower(d.RateLimit.Unit)]; !ok {
+				return fmt.Errorf("descriptor %q: invalid rate limit unit %q", d.Key, d.RateLimit.Unit)
+			}
+		}
+
+		if err := validateDescriptors(d.Descriptors); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
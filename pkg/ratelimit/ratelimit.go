@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	envoy_extensions_common_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	envoy_service_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
+)
+
+type Response = envoy_service_ratelimit_v3.RateLimitResponse
+
+// Counts are the request counts for a rate limit descriptor.
+type Counts struct {
+	Domain     string `json:"domain"`
+	Descriptor string `json:"descriptor"`
+	Limit      string `json:"limit"`
+	Allowed    uint64 `json:"allowed"`
+	OverLimit  uint64 `json:"overLimit"`
+}
+
+// window counts the hits in a fixed rate limit window.
+type window struct {
+	start time.Time
+	hits  uint32
+}
+
+// Server is an in-process implementation of the Envoy rate limit
+// service. Each configured limit is enforced over fixed time windows.
+type Server struct {
+	lock    sync.Mutex
+	domains map[string]*Config
+	windows map[string]*window
+	counts  map[string]*Counts
+}
+
+var _ envoy_service_ratelimit_v3.RateLimitServiceServer = &Server{}
+
+// NewServer returns a rate limit Server for the given domain configurations.
+func NewServer(configs ...*Config) *Server {
+	s := &Server{
+		domains: map[string]*Config{},
+		windows: map[string]*window{},
+		counts:  map[string]*Counts{},
+	}
+
+	for _, c := range configs {
+		s.domains[c.Domain] = c
+	}
+
+	return s
+}
+
+// Register registers the rate limit service on the gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	envoy_service_ratelimit_v3.RegisterRateLimitServiceServer(g, s)
+}
+
+// findLimit walks the descriptor configuration to find the limit for
+// a descriptor. At each level, an exact key and value match is
+// preferred to a key-only match.
+func findLimit(descriptors []Descriptor, entries []*envoy_extensions_common_ratelimit_v3.RateLimitDescriptor_Entry) *Limit {
+	var match *Descriptor
+
+	for _, e := range entries {
+		match = nil
+
+		for i := range descriptors {
+			d := &descriptors[i]
+			if d.Key != e.GetKey() {
+				continue
+			}
+
+			if d.Value == e.GetValue() {
+				match = d
+				break
+			}
+
+			if d.Value == "" && match == nil {
+				match = d
+			}
+		}
+
+		if match == nil {
+			return nil
+		}
+
+		descriptors = match.Descriptors
+	}
+
+	if match == nil {
+		return nil
+	}
+
+	return match.RateLimit
+}
+
+func descriptorString(d *envoy_extensions_common_ratelimit_v3.RateLimitDescriptor) string {
+	var parts []string
+	for _, e := range d.GetEntries() {
+		parts = append(parts, fmt.Sprintf("%s=%s", e.GetKey(), e.GetValue()))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// ShouldRateLimit implements RateLimitServiceServer.
+func (s *Server) ShouldRateLimit(ctx context.Context, req *envoy_service_ratelimit_v3.RateLimitRequest) (*Response, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	resp := &Response{
+		OverallCode: envoy_service_ratelimit_v3.RateLimitResponse_OK,
+	}
+
+	hits := req.GetHitsAddend()
+	if hits == 0 {
+		hits = 1
+	}
+
+	config := s.domains[req.GetDomain()]
+
+	for _, d := range req.GetDescriptors() {
+		status := &envoy_service_ratelimit_v3.RateLimitResponse_DescriptorStatus{
+			Code: envoy_service_ratelimit_v3.RateLimitResponse_OK,
+		}
+
+		resp.Statuses = append(resp.Statuses, status)
+
+		if config == nil {
+			continue
+		}
+
+		limit := findLimit(config.Descriptors, d.GetEntries())
+		if limit == nil {
+			continue
+		}
+
+		unit := strings.ToLower(limit.Unit)
+		period := units[unit]
+		key := req.GetDomain() + "|" + descriptorString(d)
+
+		w, ok := s.windows[key]
+		if !ok || now.Sub(w.start) >= period {
+			w = &window{start: now.Truncate(period)}
+			s.windows[key] = w
+		}
+
+		counts, ok := s.counts[key]
+		if !ok {
+			counts = &Counts{
+				Domain:     req.GetDomain(),
+				Descriptor: descriptorString(d),
+				Limit:      fmt.Sprintf("%d/%s", limit.RequestsPerUnit, unit),
+			}
+
+			s.counts[key] = counts
+		}
+
+		w.hits += hits
+
+		status.CurrentLimit = &envoy_service_ratelimit_v3.RateLimitResponse_RateLimit{
+			RequestsPerUnit: limit.RequestsPerUnit,
+			Unit: envoy_service_ratelimit_v3.RateLimitResponse_RateLimit_Unit(
+				envoy_service_ratelimit_v3.RateLimitResponse_RateLimit_Unit_value[strings.ToUpper(unit)]),
+		}
+
+		status.DurationUntilReset = ptypes.DurationProto(w.start.Add(period).Sub(now))
+
+		if w.hits > limit.RequestsPerUnit {
+			status.Code = envoy_service_ratelimit_v3.RateLimitResponse_OVER_LIMIT
+			resp.OverallCode = envoy_service_ratelimit_v3.RateLimitResponse_OVER_LIMIT
+			counts.OverLimit++
+
+			log.Printf("rate limited %s descriptor %s", req.GetDomain(), descriptorString(d))
+		} else {
+			status.LimitRemaining = limit.RequestsPerUnit - w.hits
+			counts.Allowed++
+		}
+	}
+
+	return resp, nil
+}
+
+// Counts returns the request counts for each limited descriptor.
+func (s *Server) Counts() []Counts {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := []Counts{}
+	for _, c := range s.counts {
+		result = append(result, *c)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Domain != result[j].Domain {
+			return result[i].Domain < result[j].Domain
+		}
+
+		return result[i].Descriptor < result[j].Descriptor
+	})
+
+	return result
+}
@@ -0,0 +1,159 @@
+package xds
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+)
+
+// AckStats summarizes the responses sent for a resource type, and
+// how long Envoy took to ACK or NACK them.
+type AckStats struct {
+	TypeURL     string        `json:"typeURL"`
+	Sent        uint64        `json:"sent"`
+	Acks        uint64        `json:"acks"`
+	Nacks       uint64        `json:"nacks"`
+	Pending     uint64        `json:"pending"`
+	LastVersion string        `json:"lastVersion,omitempty"`
+	LastLatency time.Duration `json:"lastLatency"`
+	MeanLatency time.Duration `json:"meanLatency"`
+	MaxLatency  time.Duration `json:"maxLatency"`
+}
+
+type ackKey struct {
+	stream int64
+	nonce  string
+}
+
+type sentResponse struct {
+	typeURL string
+	version string
+	when    time.Time
+}
+
+type ackCounts struct {
+	AckStats
+	total time.Duration
+}
+
+// AckTracker matches each xDS response with the request that ACKs or
+// NACKs it, so that we can tell how long Envoy takes to apply updates.
+// The response nonce is echoed back in the next request on the same
+// stream, which is an ACK unless the request carries an error detail.
+type AckTracker struct {
+	lock    sync.Mutex
+	pending map[ackKey]sentResponse
+	counts  map[string]*ackCounts
+}
+
+// NewAckTracker returns a new AckTracker.
+func NewAckTracker() *AckTracker {
+	return &AckTracker{
+		pending: map[ackKey]sentResponse{},
+		counts:  map[string]*ackCounts{},
+	}
+}
+
+func (a *AckTracker) countsFor(typeURL string) *ackCounts {
+	c, ok := a.counts[typeURL]
+	if !ok {
+		c = &ackCounts{AckStats: AckStats{TypeURL: typeURL}}
+		a.counts[typeURL] = c
+	}
+
+	return c
+}
+
+// Sent records a response sent on the given stream.
+func (a *AckTracker) Sent(streamID int64, resp *discovery.DiscoveryResponse) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.pending[ackKey{stream: streamID, nonce: resp.GetNonce()}] = sentResponse{
+		typeURL: resp.GetTypeUrl(),
+		version: resp.GetVersionInfo(),
+		when:    time.Now(),
+	}
+
+	c := a.countsFor(resp.GetTypeUrl())
+	c.Sent++
+	c.Pending++
+}
+
+// Received records a request received on the given stream. If the
+// request answers a response we sent, it is counted as an ACK or NACK.
+func (a *AckTracker) Received(streamID int64, req *discovery.DiscoveryRequest) {
+	if req.GetResponseNonce() == "" {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := ackKey{stream: streamID, nonce: req.GetResponseNonce()}
+	sent, ok := a.pending[key]
+	if !ok {
+		return
+	}
+
+	delete(a.pending, key)
+
+	latency := time.Since(sent.when)
+
+	c := a.countsFor(sent.typeURL)
+	c.Pending--
+	c.LastVersion = sent.version
+	c.LastLatency = latency
+	c.total += latency
+
+	if latency > c.MaxLatency {
+		c.MaxLatency = latency
+	}
+
+	if err := req.GetErrorDetail(); err != nil {
+		c.Nacks++
+		log.Printf("[%d] NACK of %s version %s after %s", streamID, sent.typeURL, sent.version, latency)
+	} else {
+		c.Acks++
+		log.Printf("[%d] ACK of %s version %s after %s", streamID, sent.typeURL, sent.version, latency)
+	}
+}
+
+// Closed forgets the responses that are pending on a closed stream.
+func (a *AckTracker) Closed(streamID int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for key, sent := range a.pending {
+		if key.stream == streamID {
+			a.countsFor(sent.typeURL).Pending--
+			delete(a.pending, key)
+		}
+	}
+}
+
+// Stats returns the ACK stats for each resource type, ordered by type URL.
+func (a *AckTracker) Stats() []AckStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var stats []AckStats
+
+	for _, c := range a.counts {
+		s := c.AckStats
+		if n := c.Acks + c.Nacks; n > 0 {
+			s.MeanLatency = c.total / time.Duration(n)
+		}
+
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i int, j int) bool {
+		return stats[i].TypeURL < stats[j].TypeURL
+	})
+
+	return stats
+}
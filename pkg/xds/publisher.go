@@ -0,0 +1,190 @@
+package xds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// Publisher merges the resources from a set of named sources into a
+// snapshot for each node and publishes it to a SnapshotCache. Each
+// source (a hack, an endpoint source, etc.) owns its resources and can
+// replace them at any time without disturbing the other sources.
+//
+// Shared sources are published to every node. Node sources are only
+// published to the node they were built for, which lets resources
+// depend on the node that requests them.
+type Publisher struct {
+	lock    sync.Mutex
+	cache   SnapshotCache
+	sources map[string]Snapshot
+	nodes   map[string]map[string]Snapshot
+}
+
+// NewPublisher returns a Publisher that publishes snapshots for each
+// node ID in the cache. The cache must be keyed by node ID.
+func NewPublisher(c SnapshotCache) *Publisher {
+	return &Publisher{
+		cache:   c,
+		sources: map[string]Snapshot{},
+		nodes:   map[string]map[string]Snapshot{},
+	}
+}
+
+// AddNode starts publishing snapshots for the given node ID, with the
+// given node sources, and publishes its merged snapshot. Since the
+// sources are added together, the node never sees a snapshot with
+// only some of them.
+func (p *Publisher) AddNode(node string, sources map[string]Snapshot) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.nodes[node]; !ok {
+		p.nodes[node] = map[string]Snapshot{}
+	}
+
+	for name, snap := range sources {
+		p.nodes[node][name] = snap
+	}
+
+	return p.cache.SetSnapshot(node, p.merge(node))
+}
+
+// Update replaces the resources for the named shared source and
+// publishes the merged snapshot for each node.
+func (p *Publisher) Update(source string, snap Snapshot) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.sources[source] = snap
+	return p.publishAll()
+}
+
+// Remove deletes the resources for the named shared source and
+// publishes the merged snapshot for each node.
+func (p *Publisher) Remove(source string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.sources, source)
+	return p.publishAll()
+}
+
+// UpdateNode replaces the resources for the named source of the given
+// node, and publishes the node's merged snapshot.
+func (p *Publisher) UpdateNode(node string, source string, snap Snapshot) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.nodes[node]; !ok {
+		p.nodes[node] = map[string]Snapshot{}
+	}
+
+	p.nodes[node][source] = snap
+	return p.cache.SetSnapshot(node, p.merge(node))
+}
+
+// Snapshot returns the current merged snapshot for the given node.
+func (p *Publisher) Snapshot(node string) Snapshot {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.merge(node)
+}
+
+// publishAll publishes the merged snapshot for each node, returning
+// the first error.
+func (p *Publisher) publishAll() error {
+	var first error
+
+	for node := range p.nodes {
+		if err := p.cache.SetSnapshot(node, p.merge(node)); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// merge collects the resources from the shared sources and the node's
+// sources, in source name order. If two sources publish a resource
+// with the same name, the first one wins. Each resource type is
+// versioned by a hash of its contents, so that types which don't
+// change between updates aren't pushed to Envoy again.
+func (p *Publisher) merge(node string) Snapshot {
+	sources := map[string]Snapshot{}
+	for name, snap := range p.sources {
+		sources[name] = snap
+	}
+
+	for name, snap := range p.nodes[node] {
+		sources[name] = snap
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	merged := Snapshot{}
+
+	for t := range merged.Resources {
+		items := map[string]types.ResourceWithTtl{}
+
+		for _, name := range names {
+			for k, v := range sources[name].Resources[t].Items {
+				if _, ok := items[k]; ok {
+					log.Printf("source %q: ignoring duplicate %s resource %q",
+						name, typeName(ResponseType(t)), k)
+					continue
+				}
+
+				items[k] = v
+			}
+		}
+
+		merged.Resources[t] = Resources{
+			Version: hashResources(items),
+			Items:   items,
+		}
+	}
+
+	return merged
+}
+
+// hashResources returns a version string that is derived from the
+// content of the given resources.
+func hashResources(items map[string]types.ResourceWithTtl) string {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+
+		if data, err := cache.MarshalResource(items[k].Resource); err == nil {
+			h.Write(data)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func typeName(t ResponseType) string {
+	if url, err := cache.GetResponseTypeURL(t); err == nil {
+		return url
+	}
+
+	return "unknown"
+}
@@ -47,6 +47,17 @@ const (
 	UnknownType  = types.UnknownType
 )
 
+// ResourceType returns the xDS response type for the resource type
+// URL, or UnknownType if it isn't a resource that we can publish.
+func ResourceType(typeURL string) ResponseType {
+	return cache.GetResponseType(typeURL)
+}
+
+// ResourceName returns the name of the resource.
+func ResourceName(r proto.Message) string {
+	return cache.GetResourceName(r)
+}
+
 type ConstantHash string
 
 var _ cache.NodeHash = ConstantHash("")